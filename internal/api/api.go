@@ -1,5 +1,19 @@
 package api
 
+import "github.com/DIvanCode/filestorage/pkg/bucket"
+
 type DownloadFileRequest struct {
 	File string `json:"file"`
 }
+
+// ListFilesResponse is a page of bucket entries ordered by path.
+// NextPageToken continues the listing and is empty on the last page.
+type ListFilesResponse struct {
+	Entries       []bucket.Entry `json:"entries"`
+	NextPageToken string         `json:"next_page_token,omitempty"`
+}
+
+// BucketItem is a line of the NDJSON bucket listing.
+type BucketItem struct {
+	ID bucket.ID `json:"id"`
+}
@@ -1,85 +1,638 @@
 package client
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DIvanCode/filestorage/internal/api"
 	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	"github.com/DIvanCode/filestorage/pkg/auth"
 	"github.com/DIvanCode/filestorage/pkg/bucket"
 )
 
+// signedURLValidity is how long the URL of a request signed by the client stays valid.
+const signedURLValidity = time.Minute
+
 type Client struct {
-	endpoint string
+	endpoint    string
+	prefix      string
+	httpClient  *http.Client
+	retry       retryPolicy
+	compression tarstream.Compression
+	token       string
+	signer      *auth.Signer
+}
+
+type retryPolicy struct {
+	retries      int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient makes the client send requests through httpClient instead of a default one.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetry retries interrupted downloads up to retries times with exponential backoff
+// starting at initialDelay and capped by maxDelay. A retried download continues
+// where the interrupted one stopped.
+func WithRetry(retries int, initialDelay, maxDelay time.Duration) Option {
+	return func(c *Client) {
+		c.retry = retryPolicy{
+			retries:      retries,
+			initialDelay: initialDelay,
+			maxDelay:     maxDelay,
+		}
+	}
+}
+
+// WithCompression asks the server to send archives compressed with compression and
+// compresses uploaded archives with it. The server falls back to an uncompressed
+// archive if it does not support the compression.
+func WithCompression(compression tarstream.Compression) Option {
+	return func(c *Client) {
+		c.compression = compression
+	}
+}
+
+// WithPrefix talks to a node serving its versioned API under prefix, e.g. "/api" for /api/v1.
+func WithPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.prefix = prefix
+	}
+}
+
+// WithToken authenticates every request with the bearer token; an empty token sends none.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
 }
 
-func NewClient(endpoint string) *Client {
-	return &Client{
-		endpoint: endpoint,
+// WithSigner authenticates every request by signing its URL with signer instead of sending a token.
+// The URL is signed for exactly that request, so it is granted every permission.
+func WithSigner(signer *auth.Signer) Option {
+	return func(c *Client) {
+		c.signer = signer
 	}
 }
 
-func (c *Client) DownloadBucket(ctx context.Context, id bucket.ID, path string) error {
-	httpReq, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		c.endpoint+"/bucket?id="+id.String(),
-		bytes.NewBuffer(nil))
+func NewClient(endpoint string, opts ...Option) *Client {
+	c := &Client{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		httpClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.prefix = strings.TrimRight(c.prefix, "/")
+	if c.prefix != "" && !strings.HasPrefix(c.prefix, "/") {
+		c.prefix = "/" + c.prefix
+	}
+	return c
+}
+
+// StatusError describes a response with an unexpected status code.
+// It unwraps to the pkg/errors sentinel matching the error code of the response, if there is one.
+type StatusError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("unexpected status %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Client) DownloadBucket(ctx context.Context, id bucket.ID, path string, opts ...tarstream.ReceiveOption) error {
+	bucketURL := c.bucketURL(id)
+	return c.download(ctx, path, opts, func(resume url.Values) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, withQuery(bucketURL, resume), nil)
+	})
+}
+
+func (c *Client) DownloadFile(
+	ctx context.Context,
+	bucketID bucket.ID,
+	file, path string,
+	opts ...tarstream.ReceiveOption,
+) error {
+	fileURL := c.fileURL(bucketID, file)
+	return c.download(ctx, path, opts, func(resume url.Values) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, withQuery(fileURL, resume), nil)
+	})
+}
+
+// OpenFile returns the contents of file of bucket bucketID as is, starting at offset.
+// A positive length limits the contents to that many bytes. The caller must close the returned reader.
+func (c *Client) OpenFile(
+	ctx context.Context,
+	bucketID bucket.ID,
+	file string,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("raw", "true")
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(c.fileURL(bucketID, file), query), nil)
+	if err != nil {
+		return nil, err
+	}
+	expected := http.StatusOK
+	switch {
+	case length > 0:
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		expected = http.StatusPartialContent
+	case offset > 0:
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		expected = http.StatusPartialContent
+	}
+
+	httpResp, err := c.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != expected {
+		defer func() { _ = httpResp.Body.Close() }()
+		return nil, responseError(httpResp)
+	}
+	return httpResp.Body, nil
+}
+
+func (c *Client) StatBucket(ctx context.Context, id bucket.ID) (stat bucket.Stat, err error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.bucketURL(id)+"/stat", nil)
+	if err != nil {
+		return
+	}
+
+	httpResp, err := c.do(httpReq)
+	if err != nil {
+		return
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusOK {
+		err = responseError(httpResp)
+		return
+	}
+
+	if err = json.NewDecoder(httpResp.Body).Decode(&stat); err != nil {
+		err = fmt.Errorf("failed to decode bucket stat: %w", err)
+	}
+	return
+}
+
+// ListBuckets streams the buckets matching filter with IDs greater than after to fn in ID order.
+// A zero after starts from the beginning, zero limit lists every matching bucket.
+func (c *Client) ListBuckets(
+	ctx context.Context,
+	after bucket.ID,
+	limit int,
+	filter bucket.Filter,
+	fn func(id bucket.ID) error,
+) error {
+	query := url.Values{}
+	if after != (bucket.ID{}) {
+		query.Set("after", after.String())
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if filter.Prefix != "" {
+		query.Set("prefix", filter.Prefix)
+	}
+	if filter.Expired != nil {
+		query.Set("expired", strconv.FormatBool(*filter.Expired))
+	}
+	if filter.PinOwner != "" {
+		query.Set("pin-owner", filter.PinOwner)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(c.apiURL()+"/buckets", query), nil)
 	if err != nil {
 		return err
 	}
 
-	httpClient := http.Client{}
-	httpResp, err := httpClient.Do(httpReq)
+	httpResp, err := c.do(httpReq)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = httpResp.Body.Close() }()
 
 	if httpResp.StatusCode != http.StatusOK {
-		content, err := io.ReadAll(httpResp.Body)
-		if err != nil {
+		return responseError(httpResp)
+	}
+
+	decoder := json.NewDecoder(httpResp.Body)
+	for {
+		var item api.BucketItem
+		if err := decoder.Decode(&item); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode bucket listing: %w", err)
+		}
+		if err := fn(item.ID); err != nil {
 			return err
 		}
-		return errors.New(string(content))
 	}
+}
+
+// ListFiles returns one page of the entries of bucket id; pageToken is empty for the first page
+// and limit is zero for the default page size.
+func (c *Client) ListFiles(
+	ctx context.Context,
+	id bucket.ID,
+	prefix string,
+	recursive bool,
+	pageToken string,
+	limit int,
+) (resp api.ListFilesResponse, err error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if recursive {
+		query.Set("recursive", "true")
+	}
+	if pageToken != "" {
+		query.Set("page-token", pageToken)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(c.bucketURL(id)+"/files", query), nil)
+	if err != nil {
+		return
+	}
+
+	httpResp, err := c.do(httpReq)
+	if err != nil {
+		return
+	}
+	defer func() { _ = httpResp.Body.Close() }()
 
-	return tarstream.Receive(path, httpResp.Body)
+	if httpResp.StatusCode != http.StatusOK {
+		err = responseError(httpResp)
+		return
+	}
+
+	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		err = fmt.Errorf("failed to decode bucket files: %w", err)
+	}
+	return
 }
 
-func (c *Client) DownloadFile(ctx context.Context, bucketID bucket.ID, file, path string) error {
-	req := api.DownloadFileRequest{File: file}
-	jsonReq, err := json.Marshal(req)
+func (c *Client) PinBucket(
+	ctx context.Context,
+	id bucket.ID,
+	owner string,
+	lease *time.Duration,
+) (pin bucket.Pin, err error) {
+	query := url.Values{}
+	if owner != "" {
+		query.Set("owner", owner)
+	}
+	if lease != nil {
+		query.Set("lease", lease.String())
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, withQuery(c.bucketURL(id)+"/pins", query), nil)
+	if err != nil {
+		return
+	}
+
+	httpResp, err := c.do(httpReq)
+	if err != nil {
+		return
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusCreated {
+		err = responseError(httpResp)
+		return
+	}
+
+	if err = json.NewDecoder(httpResp.Body).Decode(&pin); err != nil {
+		err = fmt.Errorf("failed to decode bucket pin: %w", err)
+	}
+	return
+}
+
+func (c *Client) UnpinBucket(ctx context.Context, id bucket.ID, pinID string) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.bucketURL(id)+"/pins/"+url.PathEscape(pinID), nil)
 	if err != nil {
 		return err
 	}
-	httpReq, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		c.endpoint+"/file?bucket-id="+bucketID.String(),
-		bytes.NewBuffer(jsonReq))
+
+	httpResp, err := c.do(httpReq)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusNoContent {
+		return responseError(httpResp)
+	}
+	return nil
+}
+
+func (c *Client) RemoveBucket(ctx context.Context, id bucket.ID) error {
+	return c.remove(ctx, c.bucketURL(id))
+}
+
+func (c *Client) RemoveFile(ctx context.Context, bucketID bucket.ID, file string) error {
+	return c.remove(ctx, c.fileURL(bucketID, file))
+}
+
+func (c *Client) UploadBucket(
+	ctx context.Context,
+	id bucket.ID,
+	path string,
+	ttl *time.Duration,
+	opts ...tarstream.SendOption,
+) error {
+	query := url.Values{}
+	if ttl != nil {
+		query.Set("ttl", ttl.String())
+	}
+
+	opts = append(slices.Clip(opts), tarstream.WithCompression(c.compression))
+	return c.upload(ctx, withQuery(c.bucketURL(id), query), func(w io.Writer) error {
+		return tarstream.Send(path, w, opts...)
+	})
+}
+
+func (c *Client) UploadFile(
+	ctx context.Context,
+	bucketID bucket.ID,
+	file, path string,
+	opts ...tarstream.SendOption,
+) error {
+	opts = append(slices.Clip(opts), tarstream.WithCompression(c.compression))
+	return c.upload(ctx, c.fileURL(bucketID, file), func(w io.Writer) error {
+		return tarstream.SendFile(file, path, w, opts...)
+	})
+}
+
+// download receives the archive into path, retrying interrupted transfers according to the retry policy.
+// newReq builds the request for an attempt; resume holds the query parameters continuing the previous one.
+func (c *Client) download(
+	ctx context.Context,
+	path string,
+	opts []tarstream.ReceiveOption,
+	newReq func(resume url.Values) (*http.Request, error),
+) error {
+	var progress tarstream.Progress
+	opts = append(slices.Clip(opts), tarstream.WithProgress(&progress))
+	delay := c.retry.initialDelay
+	for attempt := 0; ; attempt++ {
+		resume := url.Values{}
+		if progress.Entry != "" {
+			resume.Set("resume-entry", progress.Entry)
+			resume.Set("resume-offset", strconv.FormatInt(progress.Offset, 10))
+		}
+
+		err := c.downloadOnce(newReq, resume, path, opts)
+		if err == nil || attempt >= c.retry.retries || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay = min(2*delay, c.retry.maxDelay)
+	}
+}
 
-	httpClient := http.Client{}
-	httpResp, err := httpClient.Do(httpReq)
+func (c *Client) downloadOnce(
+	newReq func(resume url.Values) (*http.Request, error),
+	resume url.Values,
+	path string,
+	opts []tarstream.ReceiveOption,
+) error {
+	httpReq, err := newReq(resume)
 	if err != nil {
 		return err
 	}
+	// an explicit header also keeps the transport from negotiating gzip on its own
+	if c.compression != tarstream.CompressionNone {
+		httpReq.Header.Set("Accept-Encoding", string(c.compression))
+	} else {
+		httpReq.Header.Set("Accept-Encoding", "identity")
+	}
+
+	httpResp, err := c.do(httpReq)
+	if err != nil {
+		return &transportError{err: err}
+	}
 	defer func() { _ = httpResp.Body.Close() }()
 
 	if httpResp.StatusCode != http.StatusOK {
-		content, err := io.ReadAll(httpResp.Body)
-		if err != nil {
-			return err
+		return responseError(httpResp)
+	}
+
+	compression, err := tarstream.ParseCompression(httpResp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	opts = append(slices.Clip(opts), tarstream.WithDecompression(compression))
+
+	body := &bodyReader{r: httpResp.Body}
+	if err := tarstream.Receive(path, body, opts...); err != nil {
+		if body.err != nil {
+			return &transportError{err: err}
 		}
-		return errors.New(string(content))
+		return err
+	}
+	return nil
+}
+
+func (c *Client) upload(ctx context.Context, url string, send func(w io.Writer) error) error {
+	reader, writer := io.Pipe()
+	sendErr := make(chan error, 1)
+	go func() {
+		err := send(writer)
+		_ = writer.CloseWithError(err)
+		sendErr <- err
+	}()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, url, reader)
+	if err != nil {
+		_ = reader.CloseWithError(err)
+		<-sendErr
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-tar")
+	if c.compression != tarstream.CompressionNone {
+		httpReq.Header.Set("Content-Encoding", string(c.compression))
 	}
 
-	return tarstream.Receive(path, httpResp.Body)
+	httpResp, err := c.do(httpReq)
+	// the server may answer before consuming the whole stream; unblock the sender
+	_ = reader.Close()
+	if streamErr := <-sendErr; streamErr != nil && err != nil {
+		return streamErr
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusCreated && httpResp.StatusCode != http.StatusOK {
+		return responseError(httpResp)
+	}
+
+	return nil
+}
+
+func (c *Client) remove(ctx context.Context, url string) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	httpResp, err := c.do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusNoContent {
+		return responseError(httpResp)
+	}
+	return nil
+}
+
+// apiURL returns the URL the versioned API of the node is served at.
+func (c *Client) apiURL() string {
+	return c.endpoint + c.prefix + "/v1"
+}
+
+func (c *Client) bucketURL(id bucket.ID) string {
+	return c.apiURL() + "/buckets/" + id.String()
+}
+
+func (c *Client) fileURL(bucketID bucket.ID, file string) string {
+	return c.bucketURL(bucketID) + "/files/" + escapePath(file)
+}
+
+// withQuery appends query to target unless it is empty.
+func withQuery(target string, query url.Values) string {
+	if len(query) == 0 {
+		return target
+	}
+	return target + "?" + query.Encode()
+}
+
+// escapePath escapes every segment of the slash-separated path for use in a URL path.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (c *Client) do(httpReq *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.signer != nil {
+		permissions := auth.PermissionRead | auth.PermissionWrite | auth.PermissionDelete
+		httpReq.URL = c.signer.Sign(httpReq.Method, httpReq.URL, permissions, time.Now().Add(signedURLValidity))
+	}
+	return c.httpClient.Do(httpReq)
+}
+
+func responseError(httpResp *http.Response) error {
+	content, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+
+	statusErr := &StatusError{StatusCode: httpResp.StatusCode}
+
+	var apiErr api.Error
+	if json.Unmarshal(content, &apiErr) == nil && apiErr.Code != "" {
+		statusErr.Code = apiErr.Code
+		statusErr.Message = apiErr.Message
+		statusErr.Err = api.CodeError(apiErr.Code)
+		return statusErr
+	}
+
+	// not an api error envelope, e.g. the response of a proxy in front of the node
+	statusErr.Message = strings.TrimSpace(string(content))
+	return statusErr
+}
+
+// transportError is a failure of the connection rather than of the request itself.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// bodyReader remembers the first failure of the underlying connection, so that a
+// truncated archive can be told apart from a malformed one.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func isRetryable(err error) bool {
+	var transportErr *transportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+			http.StatusLocked:
+			return true
+		}
+	}
+	return false
 }
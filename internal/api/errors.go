@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// Error is the JSON body of every failed response.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeBadRequest          = "bad_request"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeNotFound            = "not_found"
+	CodeInternal            = "internal"
+	CodeBucketNotFound      = "bucket_not_found"
+	CodeFileNotFound        = "file_not_found"
+	CodePinNotFound         = "pin_not_found"
+	CodeBucketAlreadyExists = "bucket_already_exists"
+	CodeFileAlreadyExists   = "file_already_exists"
+	CodeInvalidPath         = "invalid_path"
+	CodeInvalidArchive      = "invalid_archive"
+	CodeArchiveTooLarge     = "archive_too_large"
+	CodeChecksumMismatch    = "checksum_mismatch"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeLockTimeout         = "lock_timeout"
+	CodeWriteLocked         = "write_locked"
+	CodeReadLocked          = "read_locked"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+)
+
+type errorCode struct {
+	code   string
+	status int
+	err    error
+}
+
+// errorCodes is ordered: the first sentinel an error matches wins.
+var errorCodes = []errorCode{
+	{code: CodeBucketNotFound, status: http.StatusNotFound, err: ErrBucketNotFound},
+	{code: CodeFileNotFound, status: http.StatusNotFound, err: ErrFileNotFound},
+	{code: CodePinNotFound, status: http.StatusNotFound, err: ErrPinNotFound},
+	{code: CodeBucketAlreadyExists, status: http.StatusConflict, err: ErrBucketAlreadyExists},
+	{code: CodeFileAlreadyExists, status: http.StatusConflict, err: ErrFileAlreadyExists},
+	{code: CodeArchiveTooLarge, status: http.StatusRequestEntityTooLarge, err: ErrArchiveTooLarge},
+	{code: CodeChecksumMismatch, status: http.StatusUnprocessableEntity, err: ErrChecksumMismatch},
+	{code: CodeInvalidArchive, status: http.StatusBadRequest, err: ErrInvalidArchive},
+	{code: CodeInvalidPath, status: http.StatusBadRequest, err: ErrInvalidPath},
+	{code: CodeQuotaExceeded, status: http.StatusInsufficientStorage, err: ErrQuotaExceeded},
+	{code: CodeLockTimeout, status: http.StatusLocked, err: ErrLockTimeout},
+	{code: CodeWriteLocked, status: http.StatusLocked, err: ErrWriteLocked},
+	{code: CodeReadLocked, status: http.StatusLocked, err: ErrReadLocked},
+	{code: CodeUnauthorized, status: http.StatusUnauthorized, err: ErrUnauthorized},
+	{code: CodeForbidden, status: http.StatusForbidden, err: ErrForbidden},
+}
+
+// ErrorCode classifies err by the pkg/errors sentinel it wraps.
+// Errors without a known sentinel are reported as internal.
+func ErrorCode(err error) (code string, status int) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code, c.status
+		}
+	}
+	return CodeInternal, http.StatusInternalServerError
+}
+
+// CodeError returns the pkg/errors sentinel for code, or nil if there is none.
+func CodeError(code string) error {
+	for _, c := range errorCodes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
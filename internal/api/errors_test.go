@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   string
+		status int
+	}{
+		{name: "wrapped not found", err: fmt.Errorf("failed to get bucket: %w", ErrBucketNotFound), code: CodeBucketNotFound, status: http.StatusNotFound},
+		{name: "archive limits", err: fmt.Errorf("%w: too many files", ErrArchiveTooLarge), code: CodeArchiveTooLarge, status: http.StatusRequestEntityTooLarge},
+		{name: "quota", err: ErrQuotaExceeded, code: CodeQuotaExceeded, status: http.StatusInsufficientStorage},
+		{name: "lock timeout", err: fmt.Errorf("%w: %w", ErrLockTimeout, context.DeadlineExceeded), code: CodeLockTimeout, status: http.StatusLocked},
+		{name: "forbidden", err: fmt.Errorf("%w: read is not allowed", ErrForbidden), code: CodeForbidden, status: http.StatusForbidden},
+		{name: "unknown", err: fmt.Errorf("disk on fire"), code: CodeInternal, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, status := ErrorCode(tt.err)
+			require.Equal(t, tt.code, code)
+			require.Equal(t, tt.status, status)
+			if tt.code != CodeInternal {
+				require.ErrorIs(t, tt.err, CodeError(code))
+			}
+		})
+	}
+}
@@ -2,14 +2,23 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/DIvanCode/filestorage/internal/api"
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/internal/lib/safepath"
 	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	"github.com/DIvanCode/filestorage/pkg/auth"
 	"github.com/DIvanCode/filestorage/pkg/bucket"
 	. "github.com/DIvanCode/filestorage/pkg/errors"
 	"github.com/go-chi/chi/v5"
@@ -17,100 +26,849 @@ import (
 
 type (
 	Handler struct {
-		storage fileStorage
+		storage       fileStorage
+		limits        tarstream.Limits
+		prefix        string
+		openAPI       []byte
+		authenticator auth.Authenticator
 	}
 
+	Option func(h *Handler)
+
 	fileStorage interface {
 		GetBucket(ctx context.Context, id bucket.ID, addTTL *time.Duration) (path string, unlock func(), err error)
 		GetFile(ctx context.Context, bucketID bucket.ID, file string, addTTL *time.Duration) (path string, unlock func(), err error)
+		ReserveBucket(ctx context.Context, id bucket.ID, ttl *time.Duration) (path string, commit, abort func() error, err error)
+		ReserveFile(ctx context.Context, bucketID bucket.ID, file string) (path string, commit, abort func() error, err error)
+		GetBucketMeta(ctx context.Context, id bucket.ID) (BucketMeta, error)
+		StatBucket(ctx context.Context, id bucket.ID) (bucket.Stat, error)
+		PinBucket(ctx context.Context, id bucket.ID, owner string, lease *time.Duration) (bucket.Pin, error)
+		UnpinBucket(ctx context.Context, id bucket.ID, pinID string) error
+		RemoveBucket(ctx context.Context, id bucket.ID) error
+		RemoveFile(ctx context.Context, bucketID bucket.ID, file string) error
+		ListFilesPage(ctx context.Context, bucketID bucket.ID, prefix string, recursive bool, after string, limit int) ([]bucket.Entry, error)
+		ListBucketsPage(ctx context.Context, after bucket.ID, limit int, filter bucket.Filter) ([]bucket.ID, error)
 	}
 )
 
-func NewHandler(storage fileStorage) *Handler {
-	return &Handler{
+// WithPrefix mounts the versioned API under prefix, e.g. "/api" serves it at /api/v1.
+func WithPrefix(prefix string) Option {
+	return func(h *Handler) {
+		h.prefix = prefix
+	}
+}
+
+// WithAuthenticator requires every request but those for the API document to be authenticated
+// by authenticator and allowed by the grant it returns. Without it the API is open to anyone
+// who can reach it.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(h *Handler) {
+		h.authenticator = authenticator
+	}
+}
+
+func NewHandler(storage fileStorage, opts ...Option) *Handler {
+	h := &Handler{
 		storage: storage,
+		limits:  tarstream.DefaultLimits(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.prefix = strings.TrimRight(h.prefix, "/")
+	if h.prefix != "" && !strings.HasPrefix(h.prefix, "/") {
+		h.prefix = "/" + h.prefix
 	}
+	h.openAPI = openAPIDocument(h.prefix + "/v1")
+	return h
 }
 
+// Register serves the versioned API under the configured prefix next to the unversioned
+// routes, which are kept for older clients.
 func (h *Handler) Register(mux *chi.Mux) {
-	mux.HandleFunc("/bucket", h.handleDownloadBucket)
-	mux.HandleFunc("/file", h.handleDownloadFile)
+	mux.HandleFunc("/buckets", h.handleListBuckets)
+	mux.HandleFunc("/bucket", h.handleBucket)
+	mux.HandleFunc("/bucket/stat", h.handleStatBucket)
+	mux.HandleFunc("/bucket/pin", h.handlePin)
+	mux.HandleFunc("/bucket/files", h.handleListFiles)
+	mux.HandleFunc("/file", h.handleFile)
+	mux.Get("/buckets/{id}/files/*", withFile(h.getFile))
+	mux.Mount(h.prefix+"/v1", h.v1Router())
+}
+
+func (h *Handler) handleBucket(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleDownloadBucket(w, r)
+	case http.MethodPut, http.MethodPost:
+		h.handleUploadBucket(w, r)
+	case http.MethodDelete:
+		h.handleRemoveBucket(w, r)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (h *Handler) handleFile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleDownloadFile(w, r)
+	case http.MethodPut, http.MethodPost:
+		h.handleUploadFile(w, r)
+	case http.MethodDelete:
+		h.handleRemoveFile(w, r)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (h *Handler) handlePin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.handlePinBucket(w, r)
+	case http.MethodDelete:
+		h.handleUnpinBucket(w, r)
+	default:
+		writeMethodNotAllowed(w)
+	}
 }
 
 func (h *Handler) handleDownloadBucket(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	var id bucket.ID
+	if err := id.FromString(r.URL.Query().Get("id")); err != nil {
+		writeBadRequest(w, err)
 		return
 	}
+	h.downloadBucket(w, r, id)
+}
 
-	query := r.URL.Query()
+func (h *Handler) downloadBucket(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+	if !h.authorize(w, r, auth.PermissionRead, id) {
+		return
+	}
 
-	var id bucket.ID
-	if err := id.FromString(query.Get("id")); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	opts, err := parseResume(r.URL.Query())
+	if err != nil {
+		writeBadRequest(w, err)
 		return
 	}
 
 	path, unlock, err := h.storage.GetBucket(r.Context(), id, nil)
 	if err != nil {
-		if errors.Is(err, ErrBucketNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeError(w, err)
 		return
 	}
 	defer unlock()
 
-	w.Header().Set("Content-Type", "application/x-tar")
-	if err := tarstream.Send(path, w); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	meta, err := h.storage.GetBucketMeta(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-}
+	opts = append(opts, tarstream.WithDigests(meta.Digests))
 
-func (h *Handler) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if compression := negotiateCompression(r.Header.Get("Accept-Encoding")); compression != tarstream.CompressionNone {
+		w.Header().Set("Content-Encoding", string(compression))
+		opts = append(opts, tarstream.WithCompression(compression))
+	}
+	if err := tarstream.Send(path, w, opts...); err != nil {
+		writeError(w, err)
 		return
 	}
+}
 
+// handleDownloadFile serves GET /file, which takes the file in the request body.
+// It is kept for older clients; GET /buckets/{id}/files/{path} replaces it.
+func (h *Handler) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
 	var id bucket.ID
 	if err := id.FromString(query.Get("bucket-id")); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
+	}
+
+	raw, err := parseRaw(query)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	// a browser cannot send a body with GET, so raw downloads name the file in the query
+	if raw {
+		h.downloadRawFile(w, r, id, query.Get("file"))
 		return
 	}
 
 	var req api.DownloadFileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, err)
 		return
 	}
+	h.downloadFile(w, r, id, req.File)
+}
 
-	path, unlock, err := h.storage.GetFile(r.Context(), id, req.File, nil)
+// getFile serves GET /buckets/{id}/files/{path}, the form of GET /file
+// that does not rely on a request body.
+func (h *Handler) getFile(w http.ResponseWriter, r *http.Request, id bucket.ID, file string) {
+	raw, err := parseRaw(r.URL.Query())
 	if err != nil {
-		if errors.Is(err, ErrInvalidPath) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else if errors.Is(err, ErrBucketNotFound) || errors.Is(err, ErrFileNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeBadRequest(w, err)
+		return
+	}
+	if raw {
+		h.downloadRawFile(w, r, id, file)
+		return
+	}
+	h.downloadFile(w, r, id, file)
+}
+
+// downloadFile sends file of bucket id wrapped into a tar archive.
+func (h *Handler) downloadFile(w http.ResponseWriter, r *http.Request, id bucket.ID, file string) {
+	if !h.authorize(w, r, auth.PermissionRead, id) {
+		return
+	}
+
+	opts, err := parseResume(r.URL.Query())
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	path, unlock, err := h.storage.GetFile(r.Context(), id, file, nil)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 	defer unlock()
 
+	meta, err := h.storage.GetBucketMeta(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	opts = append(opts, tarstream.WithDigests(meta.Digests))
+
 	w.Header().Set("Content-Type", "application/x-tar")
-	if err := tarstream.SendFile(req.File, path, w); err != nil {
-		if errors.Is(err, ErrInvalidPath) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else if errors.Is(err, os.ErrNotExist) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if compression := negotiateCompression(r.Header.Get("Accept-Encoding")); compression != tarstream.CompressionNone {
+		w.Header().Set("Content-Encoding", string(compression))
+		opts = append(opts, tarstream.WithCompression(compression))
+	}
+	if err := tarstream.SendFile(file, path, w, opts...); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: %v", ErrFileNotFound, err)
+		}
+		writeError(w, err)
+		return
+	}
+}
+
+// downloadRawFile serves a single regular file as is, so that it can be fetched
+// by a browser and read in ranges.
+func (h *Handler) downloadRawFile(w http.ResponseWriter, r *http.Request, id bucket.ID, file string) {
+	if !h.authorize(w, r, auth.PermissionRead, id) {
+		return
+	}
+
+	file, err := safepath.Clean(file)
+	if err != nil {
+		writeBadRequest(w, fmt.Errorf("invalid file: %w", err))
+		return
+	}
+	if filepath.ToSlash(file) == FileName(id) {
+		writeError(w, ErrFileNotFound)
+		return
+	}
+
+	path, unlock, err := h.storage.GetFile(r.Context(), id, file, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer unlock()
+
+	info, target, err := safepath.Lstat(path, file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: %v", ErrFileNotFound, err)
+		}
+		writeError(w, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		writeError(w, fmt.Errorf("%w: %s is not a regular file", ErrInvalidPath, file))
+		return
+	}
+
+	meta, err := h.storage.GetBucketMeta(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	f, err := os.Open(target)
+	if err != nil {
+		writeError(w, fmt.Errorf("failed to open file: %w", err))
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	// committed files never change, so their digest is a strong validator
+	if sum, ok := meta.Digests[filepath.ToSlash(file)]; ok {
+		w.Header().Set("ETag", `"`+sum+`"`)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+func (h *Handler) handleStatBucket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	var id bucket.ID
+	if err := id.FromString(r.URL.Query().Get("id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	h.statBucket(w, r, id)
+}
+
+func (h *Handler) statBucket(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+	if !h.authorize(w, r, auth.PermissionRead, id) {
+		return
+	}
+
+	stat, err := h.storage.StatBucket(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stat)
+}
+
+func (h *Handler) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	h.listBuckets(w, r)
+}
+
+// listBuckets streams the matching buckets as NDJSON, one api.BucketItem per line, reading
+// the storage a page at a time. A failure after the first line aborts the response, so a listing
+// that ends without error is complete.
+func (h *Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var after bucket.ID
+	if value := query.Get("after"); value != "" {
+		if err := after.FromString(value); err != nil {
+			writeBadRequest(w, fmt.Errorf("invalid after: %w", err))
+			return
+		}
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeBadRequest(w, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+	}
+
+	filter, err := parseFilter(query)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	// a grant limited to some buckets only lists within them
+	if !h.check(w, r, func(grant auth.Grant) bool { return grant.AllowsPrefix(auth.PermissionRead, filter.Prefix) }) {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written := 0
+	for {
+		pageSize := bucketsPageSize
+		if limit > 0 {
+			pageSize = min(pageSize, limit-written)
+		}
+
+		ids, err := h.storage.ListBucketsPage(r.Context(), after, pageSize, filter)
+		if err != nil {
+			if written == 0 {
+				writeError(w, err)
+				return
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if written == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		for _, id := range ids {
+			if err := encoder.Encode(api.BucketItem{ID: id}); err != nil {
+				return
+			}
+		}
+		written += len(ids)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(ids) < pageSize || (limit > 0 && written >= limit) {
+			return
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+func (h *Handler) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	var id bucket.ID
+	if err := id.FromString(r.URL.Query().Get("id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	h.listFiles(w, r, id)
+}
+
+func (h *Handler) listFiles(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+	if !h.authorize(w, r, auth.PermissionRead, id) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	recursive := false
+	if value := query.Get("recursive"); value != "" {
+		var err error
+		if recursive, err = strconv.ParseBool(value); err != nil {
+			writeBadRequest(w, fmt.Errorf("invalid recursive %q", value))
+			return
+		}
+	}
+
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeBadRequest(w, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxListLimit))
+			return
+		}
+	}
+
+	after, err := decodePageToken(query.Get("page-token"))
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	// one more entry than the page holds tells whether there is a next page
+	entries, err := h.storage.ListFilesPage(r.Context(), id, query.Get("prefix"), recursive, after, limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := api.ListFilesResponse{Entries: entries}
+	if len(entries) > limit {
+		resp.Entries = entries[:limit]
+		resp.NextPageToken = encodePageToken(resp.Entries[limit-1].Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) handlePinBucket(w http.ResponseWriter, r *http.Request) {
+	var id bucket.ID
+	if err := id.FromString(r.URL.Query().Get("id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	h.pinBucket(w, r, id)
+}
+
+func (h *Handler) pinBucket(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+	if !h.authorize(w, r, auth.PermissionWrite, id) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	lease, err := parseDuration("lease", query.Get("lease"))
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	pin, err := h.storage.PinBucket(r.Context(), id, query.Get("owner"), lease)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(pin)
+}
+
+func (h *Handler) handleUnpinBucket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var id bucket.ID
+	if err := id.FromString(query.Get("id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	h.unpinBucket(w, r, id, query.Get("pin"))
+}
+
+func (h *Handler) unpinBucket(w http.ResponseWriter, r *http.Request, id bucket.ID, pinID string) {
+	if !h.authorize(w, r, auth.PermissionWrite, id) {
+		return
+	}
+
+	if pinID == "" {
+		writeBadRequest(w, errors.New("pin is required"))
+		return
+	}
+
+	if err := h.storage.UnpinBucket(r.Context(), id, pinID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleUploadBucket(w http.ResponseWriter, r *http.Request) {
+	var id bucket.ID
+	if err := id.FromString(r.URL.Query().Get("id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	h.uploadBucket(w, r, id)
+}
+
+func (h *Handler) uploadBucket(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+	if !h.authorize(w, r, auth.PermissionWrite, id) {
+		return
+	}
+
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	compression, err := tarstream.ParseCompression(r.Header.Get("Content-Encoding"))
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	path, commit, abort, err := h.storage.ReserveBucket(r.Context(), id, ttl)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tarstream.ReceiveWithLimits(path, r.Body, h.limits, tarstream.WithDecompression(compression)); err != nil {
+		_ = abort()
+		writeError(w, err)
+		return
+	}
+
+	if err := commit(); err != nil {
+		_ = abort()
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var id bucket.ID
+	if err := id.FromString(query.Get("bucket-id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	h.uploadFile(w, r, id, query.Get("file"))
+}
+
+func (h *Handler) uploadFile(w http.ResponseWriter, r *http.Request, id bucket.ID, file string) {
+	if !h.authorize(w, r, auth.PermissionWrite, id) {
+		return
+	}
+
+	compression, err := tarstream.ParseCompression(r.Header.Get("Content-Encoding"))
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	path, commit, abort, err := h.storage.ReserveFile(r.Context(), id, file)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tarstream.ReceiveWithLimits(path, r.Body, h.limits, tarstream.WithDecompression(compression)); err != nil {
+		_ = abort()
+		writeError(w, err)
+		return
+	}
+
+	if err := commit(); err != nil {
+		_ = abort()
+		if errors.Is(err, os.ErrNotExist) {
+			writeBadRequest(w, fmt.Errorf("archive does not contain %q: %w", file, err))
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 		}
 		return
 	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) handleRemoveBucket(w http.ResponseWriter, r *http.Request) {
+	var id bucket.ID
+	if err := id.FromString(r.URL.Query().Get("id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	h.removeBucket(w, r, id)
+}
+
+func (h *Handler) removeBucket(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+	if !h.authorize(w, r, auth.PermissionDelete, id) {
+		return
+	}
+
+	if err := h.storage.RemoveBucket(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleRemoveFile(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var id bucket.ID
+	if err := id.FromString(query.Get("bucket-id")); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	h.removeFile(w, r, id, query.Get("file"))
+}
+
+func (h *Handler) removeFile(w http.ResponseWriter, r *http.Request, id bucket.ID, file string) {
+	if !h.authorize(w, r, auth.PermissionDelete, id) {
+		return
+	}
+
+	if err := h.storage.RemoveFile(r.Context(), id, file); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+	bucketsPageSize  = 1000
+)
+
+// parseFilter parses the optional prefix, expired and pin-owner query parameters of a bucket listing.
+func parseFilter(query url.Values) (bucket.Filter, error) {
+	filter := bucket.Filter{
+		Prefix:   query.Get("prefix"),
+		PinOwner: query.Get("pin-owner"),
+	}
+
+	if len(filter.Prefix) > len(bucket.ID{}) || strings.Trim(filter.Prefix, "0123456789abcdef") != "" {
+		return bucket.Filter{}, fmt.Errorf("invalid prefix %q", filter.Prefix)
+	}
+
+	if value := query.Get("expired"); value != "" {
+		expired, err := strconv.ParseBool(value)
+		if err != nil {
+			return bucket.Filter{}, fmt.Errorf("invalid expired %q", value)
+		}
+		filter.Expired = &expired
+	}
+
+	return filter, nil
+}
+
+// encodePageToken returns an opaque token continuing a listing after path.
+func encodePageToken(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+func decodePageToken(token string) (string, error) {
+	path, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid page token: %w", err)
+	}
+	return string(path), nil
+}
+
+// parseTTL parses an optional ttl query parameter; an empty value means the bucket never expires.
+func parseTTL(value string) (*time.Duration, error) {
+	return parseDuration("ttl", value)
+}
+
+// parseDuration parses an optional positive duration query parameter name; an empty value yields nil.
+func parseDuration(name, value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("invalid %s %q: must be positive", name, value)
+	}
+	return &d, nil
+}
+
+// parseRaw parses the optional raw query parameter asking for a single file itself instead of a tar archive.
+func parseRaw(query url.Values) (bool, error) {
+	value := query.Get("raw")
+	if value == "" {
+		return false, nil
+	}
+	raw, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid raw %q", value)
+	}
+	return raw, nil
+}
+
+// parseResume parses the optional resume-entry and resume-offset query parameters
+// that continue an interrupted download.
+func parseResume(query url.Values) ([]tarstream.SendOption, error) {
+	entry := query.Get("resume-entry")
+	if entry == "" {
+		return nil, nil
+	}
+
+	var offset int64
+	if value := query.Get("resume-offset"); value != "" {
+		var err error
+		offset, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid resume offset %q", value)
+		}
+	}
+
+	return []tarstream.SendOption{tarstream.WithResume(entry, offset)}, nil
+}
+
+// authorize checks that the request may do p on bucket id and answers it with an error if not.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, p auth.Permission, id bucket.ID) bool {
+	return h.check(w, r, func(grant auth.Grant) bool { return grant.Allows(p, id) })
+}
+
+// check authenticates the request and reports whether its grant is allowed; otherwise it answers
+// the request with 401 for missing or invalid credentials and 403 for insufficient ones.
+func (h *Handler) check(w http.ResponseWriter, r *http.Request, allowed func(grant auth.Grant) bool) bool {
+	if h.authenticator == nil {
+		return true
+	}
+
+	grant, err := h.authenticator.Authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="filestorage"`)
+		writeError(w, err)
+		return false
+	}
+	if !allowed(grant) {
+		writeError(w, fmt.Errorf("%w: insufficient permissions", ErrForbidden))
+		return false
+	}
+	return true
+}
+
+// negotiateCompression picks the compression of an archive from the Accept-Encoding
+// header of the request: the accepted coding with the highest weight, zstd on a tie.
+func negotiateCompression(acceptEncoding string) tarstream.Compression {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		weights[name] = weight
+	}
+
+	best, bestWeight := tarstream.CompressionNone, 0.0
+	for _, c := range []tarstream.Compression{tarstream.CompressionZstd, tarstream.CompressionGzip} {
+		weight, ok := weights[string(c)]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = c, weight
+		}
+	}
+	return best
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, status := api.ErrorCode(err)
+	writeJSONError(w, status, code, err.Error())
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeJSONError(w, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// errors are never compressed, even when they replace an archive that would have been
+	w.Header().Del("Content-Encoding")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(api.Error{Code: code, Message: message})
 }
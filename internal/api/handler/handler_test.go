@@ -3,14 +3,20 @@ package handler
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DIvanCode/filestorage/internal/api"
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
 	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	"github.com/DIvanCode/filestorage/pkg/auth"
 	"github.com/DIvanCode/filestorage/pkg/bucket"
 	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
 	"github.com/go-chi/chi/v5"
@@ -20,6 +26,19 @@ import (
 type stubStorage struct {
 	getFilePath string
 	getFileErr  error
+	digests     map[string]string
+
+	reservePath string
+	reserveErr  error
+	committed   *bool
+
+	unpinErr error
+
+	removeErr error
+	removed   *string
+
+	entries []bucket.Entry
+	buckets []bucket.ID
 }
 
 func (s stubStorage) GetBucket(context.Context, bucket.ID, *time.Duration) (string, func(), error) {
@@ -30,6 +49,79 @@ func (s stubStorage) GetFile(context.Context, bucket.ID, string, *time.Duration)
 	return s.getFilePath, func() {}, s.getFileErr
 }
 
+func (s stubStorage) GetBucketMeta(_ context.Context, id bucket.ID) (BucketMeta, error) {
+	return BucketMeta{BucketID: id, Digests: s.digests}, nil
+}
+
+func (s stubStorage) StatBucket(_ context.Context, id bucket.ID) (bucket.Stat, error) {
+	return bucket.Stat{ID: id, Size: 3, FileCount: 1}, nil
+}
+
+func (s stubStorage) PinBucket(_ context.Context, _ bucket.ID, owner string, lease *time.Duration) (bucket.Pin, error) {
+	pin := bucket.Pin{ID: "pin", Owner: owner}
+	if lease != nil {
+		expires := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(*lease)
+		pin.Expires = &expires
+	}
+	return pin, nil
+}
+
+func (s stubStorage) UnpinBucket(context.Context, bucket.ID, string) error {
+	return s.unpinErr
+}
+
+func (s stubStorage) RemoveBucket(_ context.Context, id bucket.ID) error {
+	if s.removed != nil {
+		*s.removed = id.String()
+	}
+	return s.removeErr
+}
+
+func (s stubStorage) RemoveFile(_ context.Context, _ bucket.ID, file string) error {
+	if s.removed != nil {
+		*s.removed = file
+	}
+	return s.removeErr
+}
+
+func (s stubStorage) ListFilesPage(_ context.Context, _ bucket.ID, _ string, _ bool, after string, limit int) ([]bucket.Entry, error) {
+	var page []bucket.Entry
+	for _, entry := range s.entries {
+		if entry.Path > after && len(page) < limit {
+			page = append(page, entry)
+		}
+	}
+	return page, nil
+}
+
+func (s stubStorage) ListBucketsPage(_ context.Context, after bucket.ID, limit int, filter bucket.Filter) ([]bucket.ID, error) {
+	var page []bucket.ID
+	for _, id := range s.buckets {
+		if string(id[:]) > string(after[:]) && strings.HasPrefix(id.String(), filter.Prefix) && len(page) < limit {
+			page = append(page, id)
+		}
+	}
+	return page, nil
+}
+
+func (s stubStorage) ReserveBucket(context.Context, bucket.ID, *time.Duration) (string, func() error, func() error, error) {
+	return s.reserve()
+}
+
+func (s stubStorage) ReserveFile(context.Context, bucket.ID, string) (string, func() error, func() error, error) {
+	return s.reserve()
+}
+
+func (s stubStorage) reserve() (string, func() error, func() error, error) {
+	commit := func() error {
+		if s.committed != nil {
+			*s.committed = true
+		}
+		return nil
+	}
+	return s.reservePath, commit, func() error { return nil }, s.reserveErr
+}
+
 func TestHandleDownloadFileClassifiesInvalidPathAsBadRequest(t *testing.T) {
 	mux := chi.NewRouter()
 	NewHandler(stubStorage{getFileErr: fserrors.ErrInvalidPath}).Register(mux)
@@ -43,6 +135,9 @@ func TestHandleDownloadFileClassifiesInvalidPathAsBadRequest(t *testing.T) {
 	mux.ServeHTTP(response, req)
 
 	require.Equal(t, http.StatusBadRequest, response.Code)
+	var apiErr api.Error
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&apiErr))
+	require.Equal(t, api.CodeInvalidPath, apiErr.Code)
 }
 
 func TestHandleDownloadFileSupportsRelativeStorageRoot(t *testing.T) {
@@ -67,3 +162,640 @@ func TestHandleDownloadFileSupportsRelativeStorageRoot(t *testing.T) {
 	require.NoError(t, tarstream.Receive(destination, response.Body))
 	require.FileExists(t, filepath.Join(destination, "checker.cpp"))
 }
+
+func TestHandleDownloadRawFile(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "logs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "logs", "output"), []byte("0123456789"), 0644))
+	sum := "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"
+
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: source, digests: map[string]string{"logs/output": sum}}).Register(mux)
+	target := "/file?bucket-id=0000000000000000000000000000000000000001&raw=true&file=logs/output"
+
+	t.Run("whole file", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "0123456789", response.Body.String())
+		require.Equal(t, "10", response.Header().Get("Content-Length"))
+		require.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+		require.Equal(t, `"`+sum+`"`, response.Header().Get("ETag"))
+		require.NotEmpty(t, response.Header().Get("Last-Modified"))
+		require.Equal(t, "bytes", response.Header().Get("Accept-Ranges"))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Range", "bytes=2-5")
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, req)
+
+		require.Equal(t, http.StatusPartialContent, response.Code)
+		require.Equal(t, "2345", response.Body.String())
+		require.Equal(t, "bytes 2-5/10", response.Header().Get("Content-Range"))
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Range", "bytes=20-")
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, req)
+
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, response.Code)
+	})
+
+	t.Run("if none match", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("If-None-Match", `"`+sum+`"`)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, req)
+
+		require.Equal(t, http.StatusNotModified, response.Code)
+		require.Empty(t, response.Body.String())
+	})
+}
+
+func TestHandleDownloadRawFileRejectsNonRegularFiles(t *testing.T) {
+	id := "0000000000000000000000000000000000000001"
+	source := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(source, "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, id+".meta.json"), []byte("{}"), 0644))
+
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: source}).Register(mux)
+
+	tests := []struct {
+		file   string
+		status int
+	}{
+		{file: "dir", status: http.StatusBadRequest},
+		{file: "../secret.txt", status: http.StatusBadRequest},
+		{file: id + ".meta.json", status: http.StatusNotFound},
+		{file: "missing.txt", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/file?bucket-id="+id+"&raw=1&file="+tt.file, nil))
+			require.Equal(t, tt.status, response.Code)
+		})
+	}
+}
+
+func TestHandleGetBucketFile(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "a b"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a b", "c%d.txt"), []byte("content"), 0644))
+
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: source}).Register(mux)
+	target := "/buckets/0000000000000000000000000000000000000001/files/a%20b/c%25d.txt"
+
+	t.Run("archive", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "application/x-tar", response.Header().Get("Content-Type"))
+		destination := t.TempDir()
+		require.NoError(t, tarstream.Receive(destination, response.Body))
+		require.FileExists(t, filepath.Join(destination, "a b", "c%d.txt"))
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target+"?raw=true", nil))
+
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "content", response.Body.String())
+	})
+}
+
+func TestHandleGetBucketFileRejectsInvalidRequests(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: t.TempDir()}).Register(mux)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{name: "invalid id", target: "/buckets/xyz/files/a.txt", status: http.StatusBadRequest},
+		{name: "escaping path", target: "/buckets/0000000000000000000000000000000000000001/files/..%2Fa.txt", status: http.StatusBadRequest},
+		{name: "missing file", target: "/buckets/0000000000000000000000000000000000000001/files/a.txt", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			require.Equal(t, tt.status, response.Code)
+		})
+	}
+}
+
+func TestHandleUploadBucket(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaa"), 0644))
+	var archive bytes.Buffer
+	require.NoError(t, tarstream.Send(source, &archive))
+
+	destination := t.TempDir()
+	committed := false
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{reservePath: destination, committed: &committed}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/bucket?id=0000000000000000000000000000000000000001&ttl=1m",
+		&archive,
+	)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusCreated, response.Code)
+	require.True(t, committed)
+	require.FileExists(t, filepath.Join(destination, "a.txt"))
+}
+
+func TestHandleDownloadFileCompressed(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "checker.cpp"), []byte("checker"), 0644))
+
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: source}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/file?bucket-id=0000000000000000000000000000000000000001",
+		bytes.NewBufferString(`{"file":"checker.cpp"}`),
+	)
+	req.Header.Set("Accept-Encoding", "gzip;q=0.5, zstd")
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "zstd", response.Header().Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", response.Header().Get("Vary"))
+	destination := t.TempDir()
+	require.NoError(t, tarstream.Receive(destination, response.Body, tarstream.WithDecompression(tarstream.CompressionZstd)))
+	require.FileExists(t, filepath.Join(destination, "checker.cpp"))
+}
+
+func TestHandleDownloadFileErrorIsNotCompressed(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: t.TempDir()}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/file?bucket-id=0000000000000000000000000000000000000001",
+		bytes.NewBufferString(`{"file":"missing.txt"}`),
+	)
+	req.Header.Set("Accept-Encoding", "gzip")
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Empty(t, response.Header().Get("Content-Encoding"))
+	var apiErr api.Error
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&apiErr))
+	require.Equal(t, api.CodeFileNotFound, apiErr.Code)
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           tarstream.Compression
+	}{
+		{acceptEncoding: "", want: tarstream.CompressionNone},
+		{acceptEncoding: "identity", want: tarstream.CompressionNone},
+		{acceptEncoding: "br, deflate", want: tarstream.CompressionNone},
+		{acceptEncoding: "gzip", want: tarstream.CompressionGzip},
+		{acceptEncoding: "GZIP, zstd", want: tarstream.CompressionZstd},
+		{acceptEncoding: "zstd;q=0.5, gzip;q=0.8", want: tarstream.CompressionGzip},
+		{acceptEncoding: "zstd;q=0, gzip;q=0", want: tarstream.CompressionNone},
+		{acceptEncoding: "*", want: tarstream.CompressionZstd},
+		{acceptEncoding: "*;q=0.1, zstd;q=0", want: tarstream.CompressionGzip},
+		{acceptEncoding: "zstd;q=x, gzip", want: tarstream.CompressionGzip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			require.Equal(t, tt.want, negotiateCompression(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestHandleUploadBucketCompressed(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaa"), 0644))
+	var archive bytes.Buffer
+	require.NoError(t, tarstream.Send(source, &archive, tarstream.WithCompression(tarstream.CompressionGzip)))
+
+	destination := t.TempDir()
+	committed := false
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{reservePath: destination, committed: &committed}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/bucket?id=0000000000000000000000000000000000000001",
+		&archive,
+	)
+	req.Header.Set("Content-Encoding", "gzip")
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusCreated, response.Code)
+	require.True(t, committed)
+	require.FileExists(t, filepath.Join(destination, "a.txt"))
+}
+
+func TestHandleUploadBucketRejectsUnsupportedEncoding(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/bucket?id=0000000000000000000000000000000000000001",
+		bytes.NewBuffer(nil),
+	)
+	req.Header.Set("Content-Encoding", "br")
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestHandleUploadBucketAlreadyExists(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{reserveErr: fserrors.ErrBucketAlreadyExists}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/bucket?id=0000000000000000000000000000000000000001",
+		bytes.NewBuffer(nil),
+	)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusConflict, response.Code)
+	var apiErr api.Error
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&apiErr))
+	require.Equal(t, api.CodeBucketAlreadyExists, apiErr.Code)
+}
+
+func TestHandleUploadBucketRejectsInvalidTTL(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+	for _, ttl := range []string{"forever", "-1h", "0s"} {
+		req := httptest.NewRequest(
+			http.MethodPut,
+			"/bucket?id=0000000000000000000000000000000000000001&ttl="+ttl,
+			bytes.NewBuffer(nil),
+		)
+		response := httptest.NewRecorder()
+
+		mux.ServeHTTP(response, req)
+
+		require.Equal(t, http.StatusBadRequest, response.Code, ttl)
+	}
+}
+
+func TestHandleUploadFileAlreadyExists(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{reserveErr: fserrors.ErrFileAlreadyExists}).Register(mux)
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/file?bucket-id=0000000000000000000000000000000000000001&file=a.txt",
+		bytes.NewBuffer(nil),
+	)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusConflict, response.Code)
+}
+
+func TestHandleStatBucket(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+	req := httptest.NewRequest(http.MethodGet, "/bucket/stat?id=0000000000000000000000000000000000000001", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	var stat bucket.Stat
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&stat))
+	require.Equal(t, "0000000000000000000000000000000000000001", stat.ID.String())
+	require.Equal(t, int64(3), stat.Size)
+	require.Equal(t, 1, stat.FileCount)
+}
+
+func TestHandlePinBucket(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+	req := httptest.NewRequest(http.MethodPost, "/bucket/pin?id=0000000000000000000000000000000000000001&owner=job&lease=1h", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusCreated, response.Code)
+	var pin bucket.Pin
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&pin))
+	require.Equal(t, "pin", pin.ID)
+	require.Equal(t, "job", pin.Owner)
+	require.Equal(t, time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), pin.Expires.UTC())
+}
+
+func TestHandleUnpinBucket(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+	req := httptest.NewRequest(http.MethodDelete, "/bucket/pin?id=0000000000000000000000000000000000000001&pin=pin", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusNoContent, response.Code)
+}
+
+func TestHandleUnpinBucketNotFound(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{unpinErr: fserrors.ErrPinNotFound}).Register(mux)
+	req := httptest.NewRequest(http.MethodDelete, "/bucket/pin?id=0000000000000000000000000000000000000001&pin=other", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusNotFound, response.Code)
+	var apiErr api.Error
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&apiErr))
+	require.Equal(t, api.CodePinNotFound, apiErr.Code)
+}
+
+func TestHandleRemoveBucket(t *testing.T) {
+	var removed string
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{removed: &removed}).Register(mux)
+	req := httptest.NewRequest(http.MethodDelete, "/bucket?id=0000000000000000000000000000000000000001", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Equal(t, "0000000000000000000000000000000000000001", removed)
+}
+
+func TestHandleRemoveFile(t *testing.T) {
+	var removed string
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{removed: &removed}).Register(mux)
+	req := httptest.NewRequest(http.MethodDelete, "/file?bucket-id=0000000000000000000000000000000000000001&file=a/b.txt", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Equal(t, "a/b.txt", removed)
+}
+
+func TestHandleRemoveFileNotFound(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{removeErr: fserrors.ErrFileNotFound}).Register(mux)
+	req := httptest.NewRequest(http.MethodDelete, "/file?bucket-id=0000000000000000000000000000000000000001&file=a.txt", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestHandleListFilesPaginates(t *testing.T) {
+	storage := stubStorage{entries: []bucket.Entry{
+		{Path: "a.txt", Type: bucket.EntryFile, Size: 1},
+		{Path: "b", Type: bucket.EntryDir},
+		{Path: "b/c.txt", Type: bucket.EntryFile, Size: 2},
+	}}
+	mux := chi.NewRouter()
+	NewHandler(storage).Register(mux)
+
+	var paths []string
+	token := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+
+		req := httptest.NewRequest(
+			http.MethodGet,
+			"/bucket/files?id=0000000000000000000000000000000000000001&recursive=true&limit=2&page-token="+token,
+			nil,
+		)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, req)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var resp api.ListFilesResponse
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&resp))
+		for _, entry := range resp.Entries {
+			paths = append(paths, entry.Path)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		token = resp.NextPageToken
+	}
+
+	require.Equal(t, []string{"a.txt", "b", "b/c.txt"}, paths)
+}
+
+func TestHandleListFilesRejectsInvalidLimit(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+	req := httptest.NewRequest(http.MethodGet, "/bucket/files?id=0000000000000000000000000000000000000001&limit=0", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, req)
+
+	require.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestHandleListBuckets(t *testing.T) {
+	var storage stubStorage
+	for _, idStr := range []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"1000000000000000000000000000000000000003",
+	} {
+		var id bucket.ID
+		require.NoError(t, id.FromString(idStr))
+		storage.buckets = append(storage.buckets, id)
+	}
+	mux := chi.NewRouter()
+	NewHandler(storage).Register(mux)
+
+	list := func(query string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/buckets?"+query, nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, req)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "application/x-ndjson", response.Header().Get("Content-Type"))
+
+		var ids []string
+		decoder := json.NewDecoder(response.Body)
+		for decoder.More() {
+			var item api.BucketItem
+			require.NoError(t, decoder.Decode(&item))
+			ids = append(ids, item.ID.String())
+		}
+		return ids
+	}
+
+	require.Len(t, list(""), 3)
+	require.Equal(t, []string{"0000000000000000000000000000000000000002"},
+		list("after=0000000000000000000000000000000000000001&limit=1"))
+	require.Equal(t, []string{"1000000000000000000000000000000000000003"}, list("prefix=1"))
+}
+
+func TestHandleListBucketsRejectsInvalidFilter(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}).Register(mux)
+
+	for _, query := range []string{"prefix=XYZ", "expired=maybe", "limit=-1", "after=1"} {
+		req := httptest.NewRequest(http.MethodGet, "/buckets?"+query, nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, req)
+		require.Equal(t, http.StatusBadRequest, response.Code, query)
+	}
+}
+
+func TestHandlerAuthorization(t *testing.T) {
+	tokens, err := auth.NewTokens(map[string]auth.Grant{
+		"reader":  {Permissions: auth.PermissionRead},
+		"limited": {Permissions: auth.PermissionRead | auth.PermissionDelete, Prefixes: []string{"ab"}},
+	})
+	require.NoError(t, err)
+	signer, err := auth.NewSigner([]byte("key"))
+	require.NoError(t, err)
+
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaa"), 0644))
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{getFilePath: source}, WithAuthenticator(auth.Chain(tokens, signer))).Register(mux)
+
+	signed := signer.Sign(
+		http.MethodGet,
+		&url.URL{Path: "/v1/buckets/0000000000000000000000000000000000000001/files/a.txt", RawQuery: "raw=true"},
+		auth.PermissionRead,
+		time.Now().Add(time.Minute),
+	)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+		code   string
+	}{
+		{
+			name:   "no credentials",
+			method: http.MethodGet,
+			target: "/v1/buckets/0000000000000000000000000000000000000001/stat",
+			status: http.StatusUnauthorized,
+			code:   api.CodeUnauthorized,
+		},
+		{
+			name:   "invalid token",
+			method: http.MethodGet,
+			target: "/v1/buckets/0000000000000000000000000000000000000001/stat",
+			token:  "wrong",
+			status: http.StatusUnauthorized,
+			code:   api.CodeUnauthorized,
+		},
+		{
+			name:   "read",
+			method: http.MethodGet,
+			target: "/bucket/stat?id=0000000000000000000000000000000000000001",
+			token:  "reader",
+			status: http.StatusOK,
+		},
+		{
+			name:   "delete without permission",
+			method: http.MethodDelete,
+			target: "/v1/buckets/0000000000000000000000000000000000000001",
+			token:  "reader",
+			status: http.StatusForbidden,
+			code:   api.CodeForbidden,
+		},
+		{
+			name:   "outside of prefixes",
+			method: http.MethodDelete,
+			target: "/v1/buckets/0000000000000000000000000000000000000001",
+			token:  "limited",
+			status: http.StatusForbidden,
+			code:   api.CodeForbidden,
+		},
+		{
+			name:   "within prefixes",
+			method: http.MethodDelete,
+			target: "/v1/buckets/ab00000000000000000000000000000000000001",
+			token:  "limited",
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "listing outside of prefixes",
+			method: http.MethodGet,
+			target: "/v1/buckets",
+			token:  "limited",
+			status: http.StatusForbidden,
+			code:   api.CodeForbidden,
+		},
+		{
+			name:   "listing within prefixes",
+			method: http.MethodGet,
+			target: "/v1/buckets?prefix=ab1",
+			token:  "limited",
+			status: http.StatusOK,
+		},
+		{name: "signed url", method: http.MethodGet, target: signed.String(), status: http.StatusOK},
+		{
+			name:   "signed url for another file",
+			method: http.MethodGet,
+			target: strings.Replace(signed.String(), "a.txt", "b.txt", 1),
+			status: http.StatusUnauthorized,
+			code:   api.CodeUnauthorized,
+		},
+		{
+			name:   "signed url with another method",
+			method: http.MethodDelete,
+			target: signed.String(),
+			status: http.StatusUnauthorized,
+			code:   api.CodeUnauthorized,
+		},
+		{name: "api document", method: http.MethodGet, target: "/v1/openapi.json", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			response := httptest.NewRecorder()
+
+			mux.ServeHTTP(response, req)
+
+			require.Equal(t, tt.status, response.Code)
+			if tt.code != "" {
+				var apiErr api.Error
+				require.NoError(t, json.NewDecoder(response.Body).Decode(&apiErr))
+				require.Equal(t, tt.code, apiErr.Code)
+			}
+			if tt.status == http.StatusUnauthorized {
+				require.NotEmpty(t, response.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/DIvanCode/filestorage/internal/api"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/go-chi/chi/v5"
+)
+
+// openAPISpec describes the routes of v1Router; a test keeps the two in sync.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// v1Router routes the versioned API. Resources are addressed by path and operations
+// by method, so every route serves the methods it declares and nothing else.
+func (h *Handler) v1Router() chi.Router {
+	r := chi.NewRouter()
+	r.NotFound(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSONError(w, http.StatusNotFound, api.CodeNotFound, "Not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, _ *http.Request) {
+		writeMethodNotAllowed(w)
+	})
+
+	r.Get("/openapi.json", h.handleOpenAPI)
+
+	r.Get("/buckets", h.listBuckets)
+	r.Get("/buckets/{id}", withBucketID(h.downloadBucket))
+	r.Put("/buckets/{id}", withBucketID(h.uploadBucket))
+	r.Delete("/buckets/{id}", withBucketID(h.removeBucket))
+	r.Get("/buckets/{id}/stat", withBucketID(h.statBucket))
+
+	r.Post("/buckets/{id}/pins", withBucketID(h.pinBucket))
+	r.Delete("/buckets/{id}/pins/{pin}", withBucketID(func(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+		h.unpinBucket(w, r, id, chi.URLParam(r, "pin"))
+	}))
+
+	r.Get("/buckets/{id}/files", withBucketID(h.listFiles))
+	r.Get("/buckets/{id}/files/*", withFile(h.getFile))
+	r.Put("/buckets/{id}/files/*", withFile(h.uploadFile))
+	r.Delete("/buckets/{id}/files/*", withFile(h.removeFile))
+
+	return r
+}
+
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(h.openAPI)
+}
+
+// openAPIDocument returns the embedded document with its server set to where the API is mounted.
+func openAPIDocument(base string) []byte {
+	var doc map[string]any
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		panic(fmt.Sprintf("invalid embedded openapi document: %v", err))
+	}
+	doc["servers"] = []map[string]string{{"url": base}}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode openapi document: %v", err))
+	}
+	return data
+}
+
+// withBucketID passes the bucket named by the {id} URL parameter to next.
+func withBucketID(next func(w http.ResponseWriter, r *http.Request, id bucket.ID)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var id bucket.ID
+		if err := id.FromString(chi.URLParam(r, "id")); err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		next(w, r, id)
+	}
+}
+
+// withFile passes the bucket named by the {id} URL parameter and the file path
+// matched by the trailing wildcard to next.
+func withFile(next func(w http.ResponseWriter, r *http.Request, id bucket.ID, file string)) http.HandlerFunc {
+	return withBucketID(func(w http.ResponseWriter, r *http.Request, id bucket.ID) {
+		// chi routes on the escaped path when it differs from the decoded one
+		file := chi.URLParam(r, "*")
+		if r.URL.RawPath != "" {
+			unescaped, err := url.PathUnescape(file)
+			if err != nil {
+				writeBadRequest(w, fmt.Errorf("invalid file: %w", err))
+				return
+			}
+			file = unescaped
+		}
+		next(w, r, id, file)
+	})
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DIvanCode/filestorage/internal/api"
+	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+const testBucketID = "0000000000000000000000000000000000000001"
+
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	var routes []string
+	err := chi.Walk(NewHandler(stubStorage{}).v1Router(), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+strings.Replace(route, "*", "{path}", 1))
+		return nil
+	})
+	require.NoError(t, err)
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPISpec, &doc))
+	var documented []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+
+	require.ElementsMatch(t, routes, documented)
+}
+
+func TestOpenAPIDocumentReferencesResolve(t *testing.T) {
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(openAPISpec, &doc))
+
+	var check func(node any)
+	check = func(node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			if ref, ok := node["$ref"].(string); ok {
+				var target any = doc
+				for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					object, ok := target.(map[string]any)
+					require.True(t, ok, ref)
+					target, ok = object[key]
+					require.True(t, ok, ref)
+				}
+			}
+			for _, child := range node {
+				check(child)
+			}
+		case []any:
+			for _, child := range node {
+				check(child)
+			}
+		}
+	}
+	check(doc)
+}
+
+func TestV1ServesOpenAPIDocument(t *testing.T) {
+	mux := chi.NewRouter()
+	NewHandler(stubStorage{}, WithPrefix("api/")).Register(mux)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+
+	require.Equal(t, http.StatusOK, response.Code)
+	var doc struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&doc))
+	require.Len(t, doc.Servers, 1)
+	require.Equal(t, "/api/v1", doc.Servers[0].URL)
+}
+
+func TestV1Routes(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		status  int
+		code    string
+		removed string
+	}{
+		{name: "stat", method: http.MethodGet, target: "/v1/buckets/" + testBucketID + "/stat", status: http.StatusOK},
+		{name: "list files", method: http.MethodGet, target: "/v1/buckets/" + testBucketID + "/files", status: http.StatusOK},
+		{name: "pin", method: http.MethodPost, target: "/v1/buckets/" + testBucketID + "/pins?owner=ci", status: http.StatusCreated},
+		{name: "unpin", method: http.MethodDelete, target: "/v1/buckets/" + testBucketID + "/pins/pin", status: http.StatusNoContent},
+		{
+			name:    "remove bucket",
+			method:  http.MethodDelete,
+			target:  "/v1/buckets/" + testBucketID,
+			status:  http.StatusNoContent,
+			removed: testBucketID,
+		},
+		{
+			name:    "remove file",
+			method:  http.MethodDelete,
+			target:  "/v1/buckets/" + testBucketID + "/files/a/b.txt",
+			status:  http.StatusNoContent,
+			removed: "a/b.txt",
+		},
+		{name: "list buckets", method: http.MethodGet, target: "/v1/buckets", status: http.StatusOK},
+		{
+			name:   "invalid id",
+			method: http.MethodGet,
+			target: "/v1/buckets/xyz/stat",
+			status: http.StatusBadRequest,
+			code:   api.CodeBadRequest,
+		},
+		{
+			name:   "method not allowed",
+			method: http.MethodPost,
+			target: "/v1/buckets/" + testBucketID,
+			status: http.StatusMethodNotAllowed,
+			code:   api.CodeMethodNotAllowed,
+		},
+		{name: "not found", method: http.MethodGet, target: "/v1/bucket", status: http.StatusNotFound, code: api.CodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var removed string
+			mux := chi.NewRouter()
+			NewHandler(stubStorage{removed: &removed}).Register(mux)
+			response := httptest.NewRecorder()
+
+			mux.ServeHTTP(response, httptest.NewRequest(tt.method, tt.target, nil))
+
+			require.Equal(t, tt.status, response.Code)
+			require.Equal(t, tt.removed, removed)
+			if tt.code != "" {
+				var apiErr api.Error
+				require.NoError(t, json.NewDecoder(response.Body).Decode(&apiErr))
+				require.Equal(t, tt.code, apiErr.Code)
+			}
+		})
+	}
+}
+
+func TestV1UploadDownload(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a", "b.txt"), []byte("bbb"), 0644))
+	var archive bytes.Buffer
+	require.NoError(t, tarstream.SendFile("a/b.txt", source, &archive))
+
+	destination := t.TempDir()
+	committed := false
+	mux := chi.NewRouter()
+	NewHandler(
+		stubStorage{reservePath: destination, committed: &committed, getFilePath: destination},
+		WithPrefix("/api"),
+	).Register(mux)
+
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodPut, "/api/v1/buckets/"+testBucketID+"/files/a/b.txt", &archive))
+	require.Equal(t, http.StatusCreated, response.Code)
+	require.True(t, committed)
+
+	response = httptest.NewRecorder()
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v1/buckets/"+testBucketID+"/files/a/b.txt?raw=1", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "bbb", response.Body.String())
+
+	response = httptest.NewRecorder()
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/v1/buckets/"+testBucketID+"/stat", nil))
+	require.Equal(t, http.StatusNotFound, response.Code)
+
+	var stat bucket.Stat
+	response = httptest.NewRecorder()
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v1/buckets/"+testBucketID+"/stat", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&stat))
+	require.Equal(t, testBucketID, stat.ID.String())
+}
@@ -1,11 +1,103 @@
 package meta
 
 import (
-	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
 )
 
+// CurrentVersion is the version of the meta format written by Marshal.
+const CurrentVersion = 1
+
+// ErrUnsupportedVersion is returned for meta written in a format newer than CurrentVersion.
+var ErrUnsupportedVersion = errors.New("unsupported bucket meta version")
+
 type BucketMeta struct {
+	// Version is the format of the meta; files written before versioning have none and decode as 0
+	Version   int        `json:"version"`
 	BucketID  bucket.ID  `json:"id"`
 	TrashTime *time.Time `json:"trash_time,omitempty"`
+
+	// Digests holds the hex encoded SHA-256 of every file keyed by its slash-separated path in the bucket
+	Digests map[string]string `json:"digests,omitempty"`
+	// Root is the Merkle root of Digests
+	Root string `json:"root,omitempty"`
+	// Stats is nil for buckets committed before stats were recorded
+	Stats *Stats `json:"stats,omitempty"`
+
+	// Pins keep the bucket from being trashed or evicted; expired pins are dropped on the next update
+	Pins []bucket.Pin `json:"pins,omitempty"`
+}
+
+// ActivePins returns the pins that still hold at now.
+func (m BucketMeta) ActivePins(now time.Time) []bucket.Pin {
+	var pins []bucket.Pin
+	for _, pin := range m.Pins {
+		if pin.Active(now) {
+			pins = append(pins, pin)
+		}
+	}
+	return pins
+}
+
+// Pinned reports whether the bucket holds at least one active pin at now.
+func (m BucketMeta) Pinned(now time.Time) bool {
+	return len(m.ActivePins(now)) > 0
+}
+
+// Stats describes the contents of a bucket, its meta file excluded.
+type Stats struct {
+	Size  int64 `json:"size"`
+	Files int   `json:"files"`
+	Dirs  int   `json:"dirs"`
+}
+
+func (s *Stats) Add(other Stats) {
+	s.Size += other.Size
+	s.Files += other.Files
+	s.Dirs += other.Dirs
+}
+
+func (s *Stats) Sub(other Stats) {
+	s.Size -= other.Size
+	s.Files -= other.Files
+	s.Dirs -= other.Dirs
+}
+
+// migrations[v] upgrades meta of version v to version v+1.
+var migrations = []func(meta *BucketMeta){
+	// version 0 is the unversioned format, which has the same fields
+	func(meta *BucketMeta) {},
+}
+
+// FileName returns the name of the meta file kept in the root of bucket id.
+func FileName(id bucket.ID) string {
+	return id.String() + ".meta.json"
+}
+
+// Marshal encodes meta in the current format.
+func Marshal(meta BucketMeta) ([]byte, error) {
+	meta.Version = CurrentVersion
+	return json.Marshal(meta)
+}
+
+// Unmarshal decodes meta written in the current or any older format and migrates it to the current one.
+func Unmarshal(data []byte) (BucketMeta, error) {
+	var meta BucketMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return BucketMeta{}, err
+	}
+
+	if meta.Version < 0 || meta.Version > CurrentVersion {
+		return BucketMeta{}, fmt.Errorf("%w %d", ErrUnsupportedVersion, meta.Version)
+	}
+	for meta.Version < CurrentVersion {
+		migrations[meta.Version](&meta)
+		meta.Version++
+	}
+
+	return meta, nil
 }
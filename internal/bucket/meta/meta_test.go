@@ -0,0 +1,59 @@
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	require.Len(t, migrations, CurrentVersion)
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	var id bucket.ID
+	require.NoError(t, id.FromString("0123456789abcdef0123456789abcdef01234567"))
+	trashTime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	data, err := Marshal(BucketMeta{BucketID: id, TrashTime: &trashTime, Digests: map[string]string{"a": "b"}, Root: "c"})
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"version":1`)
+
+	meta, err := Unmarshal(data)
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, meta.Version)
+	require.Equal(t, id, meta.BucketID)
+	require.True(t, trashTime.Equal(*meta.TrashTime))
+	require.Equal(t, map[string]string{"a": "b"}, meta.Digests)
+	require.Equal(t, "c", meta.Root)
+}
+
+func TestUnmarshal_Unversioned(t *testing.T) {
+	meta, err := Unmarshal([]byte(`{"id":"0123456789abcdef0123456789abcdef01234567","trash_time":"2025-01-02T03:04:05Z"}`))
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, meta.Version)
+	require.Equal(t, "0123456789abcdef0123456789abcdef01234567", meta.BucketID.String())
+	require.NotNil(t, meta.TrashTime)
+	require.Empty(t, meta.Root)
+}
+
+func TestUnmarshal_NewerVersion(t *testing.T) {
+	_, err := Unmarshal([]byte(`{"version":100,"id":"0123456789abcdef0123456789abcdef01234567"}`))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestPinned(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	leased := now.Add(time.Minute)
+
+	meta := BucketMeta{Pins: []bucket.Pin{{ID: "a", Expires: &expired}}}
+	require.False(t, meta.Pinned(now))
+
+	meta.Pins = append(meta.Pins, bucket.Pin{ID: "b", Expires: &leased}, bucket.Pin{ID: "c"})
+	require.True(t, meta.Pinned(now))
+	require.Equal(t, []bucket.Pin{meta.Pins[1], meta.Pins[2]}, meta.ActivePins(now))
+	require.Equal(t, []bucket.Pin{meta.Pins[2]}, meta.ActivePins(leased))
+}
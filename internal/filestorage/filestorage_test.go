@@ -1,12 +1,16 @@
 package filestorage
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"log/slog"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -45,6 +49,11 @@ func newTestStorage(t *testing.T, rootDir string) *testStorage {
 			CollectorIterationsDelay: 1,
 			WorkerIterationsDelay:    1,
 		},
+		Client: config.ClientConfig{
+			Retries:           3,
+			RetryInitialDelay: 10,
+			RetryMaxDelay:     100,
+		},
 	}
 	mux := chi.NewRouter()
 
@@ -320,3 +329,328 @@ func Test_BucketTrashedAfterTrashTime(t *testing.T) {
 	err = dst.DownloadBucket(ctx, src.endpoint, ID, &ttl)
 	require.Error(t, err)
 }
+
+func Test_UploadBucket(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, commit())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, src.UploadBucket(ctx, dst.endpoint, ID, nil))
+	require.NoError(t, src.UploadBucket(ctx, dst.endpoint, ID, nil))
+
+	srcTrashTime, err := src.GetBucketTrashTime(context.Background(), ID)
+	require.NoError(t, err)
+	dstTrashTime, err := dst.GetBucketTrashTime(context.Background(), ID)
+	require.NoError(t, err)
+	require.NotNil(t, dstTrashTime)
+	assert.WithinDuration(t, *srcTrashTime, *dstTrashTime, time.Second)
+
+	path, unlock, err := dst.GetBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	defer unlock()
+
+	content, err := os.ReadFile(filepath.Join(path, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "aaa", string(content))
+}
+
+func Test_UploadBucket_Expired(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := -time.Hour
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, commit())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.ErrorIs(t, src.UploadBucket(ctx, dst.endpoint, ID, nil), ErrBucketNotFound)
+
+	_, _, err = dst.GetBucket(context.Background(), ID, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func Test_UploadFile(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a", "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "b.txt"), []byte("bbb"), 0644))
+	require.NoError(t, commit())
+
+	_, commit, _, err = dst.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, commit())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, src.UploadFile(ctx, dst.endpoint, ID, "a/a.txt"))
+	require.NoError(t, src.UploadFile(ctx, dst.endpoint, ID, "a/a.txt"))
+
+	path, unlock, err := dst.GetBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	defer unlock()
+
+	_, err = os.Stat(filepath.Join(path, "a", "a.txt"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(path, "b.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// cutWriter aborts the connection after limit bytes of the response body.
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(b []byte) (int, error) {
+	if len(b) <= w.limit {
+		w.limit -= len(b)
+		return w.ResponseWriter.Write(b)
+	}
+	_, _ = w.ResponseWriter.Write(b[:w.limit])
+	w.ResponseWriter.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func Test_DownloadBucket_ResumesInterruptedTransfer(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	var requests atomic.Int32
+	var resumed atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w = &cutWriter{ResponseWriter: w, limit: 20_000}
+		} else {
+			resumed.Store(r.URL.Query().Get("resume-entry") == "a.bin")
+		}
+		src.srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+	content := bytes.Repeat([]byte("0123456789"), 10_000)
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.bin"), content, 0644))
+	require.NoError(t, commit())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, dst.DownloadBucket(ctx, flaky.URL, ID, &ttl))
+	require.Equal(t, int32(2), requests.Load())
+	require.True(t, resumed.Load())
+
+	path, unlock, err := dst.GetBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	defer unlock()
+
+	actual, err := os.ReadFile(filepath.Join(path, "a.bin"))
+	require.NoError(t, err)
+	require.Equal(t, content, actual)
+}
+
+func Test_DownloadBucketFrom_FailsOver(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	down := newTestStorage(t, "down")
+	defer down.Shutdown()
+	empty := newTestStorage(t, "empty")
+	defer empty.Shutdown()
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	down.srv.Close()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := dst.DownloadBucketFrom(ctx, []string{empty.endpoint, src.endpoint}, ID, &ttl)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, commit())
+
+	err = dst.DownloadBucketFrom(ctx, []string{down.endpoint, empty.endpoint, src.endpoint}, ID, &ttl)
+	require.NoError(t, err)
+
+	path, unlock, err := dst.GetBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	defer unlock()
+
+	_, err = os.Stat(filepath.Join(path, "a.txt"))
+	require.NoError(t, err)
+}
+
+func Test_DownloadBucketFrom_AllPeersFailed(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	down := newTestStorage(t, "down")
+	defer down.Shutdown()
+	empty := newTestStorage(t, "empty")
+	defer empty.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	down.srv.Close()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := dst.DownloadBucketFrom(ctx, []string{down.endpoint, empty.endpoint}, ID, &ttl)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrBucketNotFound)
+
+	_, _, err = dst.GetBucket(context.Background(), ID, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func Test_DownloadBucket_CoalescesConcurrentDownloads(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	var requests atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		src.srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, commit())
+
+	impatientCtx, cancelImpatient := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelImpatient()
+	impatientErr := make(chan error, 1)
+	go func() {
+		impatientErr <- dst.DownloadBucket(impatientCtx, slow.URL, ID, &ttl)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = dst.DownloadBucket(ctx, slow.URL, ID, &ttl)
+		}()
+	}
+	wg.Wait()
+
+	require.ErrorIs(t, <-impatientErr, context.DeadlineExceeded)
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), requests.Load())
+
+	path, unlock, err := dst.GetBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	defer unlock()
+
+	_, err = os.Stat(filepath.Join(path, "a.txt"))
+	require.NoError(t, err)
+}
+
+func Test_DownloadBucket_RejectsCorruptedData(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+
+	src := newTestStorage(t, "src")
+	defer src.Shutdown()
+	dst := newTestStorage(t, "dst")
+	defer dst.Shutdown()
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	path, commit, _, err := src.ReserveBucket(context.Background(), ID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, commit())
+
+	path, unlock, err := src.GetBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("bbb"), 0644))
+	unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = dst.DownloadBucket(ctx, src.endpoint, ID, &ttl)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+
+	_, _, err = dst.GetBucket(context.Background(), ID, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
@@ -0,0 +1,121 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// File returns the hex encoded SHA-256 of the file at path.
+func File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	sum, err := Reader(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	return sum, nil
+}
+
+// Reader returns the hex encoded SHA-256 of everything read from r.
+func Reader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Dir returns the digests of all regular files under root keyed by their
+// slash-separated path relative to root. Paths for which skip returns true are left out.
+// Symlinks and other special files are rejected.
+func Dir(root string, skip func(rel string) bool) (map[string]string, error) {
+	return DirFunc(root, skip, File)
+}
+
+// DirFunc works like Dir but hashes every file with sum, e.g. to throttle reads.
+func DirFunc(root string, skip func(rel string) bool, sum func(path string) (string, error)) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return fmt.Errorf("failed to walk filepath: %w", walkErr)
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path of %s: %w", path, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." || info.IsDir() {
+			return nil
+		}
+		if skip != nil && skip(rel) {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: unsupported file type %s", fserrors.ErrInvalidPath, rel)
+		}
+
+		fileSum, err := sum(path)
+		if err != nil {
+			return err
+		}
+		digests[rel] = fileSum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// Root returns the hex encoded Merkle root of digests. Leaves are ordered by path
+// and commit to both the path and the digest, so renaming a file changes the root.
+func Root(digests map[string]string) string {
+	paths := make([]string, 0, len(digests))
+	for path := range digests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	level := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		h := sha256.New()
+		h.Write([]byte{0})
+		h.Write([]byte(path))
+		h.Write([]byte{0})
+		h.Write([]byte(digests[path]))
+		level = append(level, h.Sum(nil))
+	}
+	if len(level) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0])
+}
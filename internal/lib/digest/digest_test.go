@@ -0,0 +1,42 @@
+package digest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b", "c.txt"), []byte("abc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "skipped.json"), []byte("{}"), 0644))
+
+	digests, err := Dir(root, func(rel string) bool { return rel == "skipped.json" })
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"a/b/c.txt": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}, digests)
+}
+
+func TestDirRejectsSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks are unavailable: %v", err)
+	}
+
+	_, err := Dir(root, nil)
+	require.ErrorIs(t, err, fserrors.ErrInvalidPath)
+}
+
+func TestRoot(t *testing.T) {
+	digests := map[string]string{"a": "1", "b": "2", "c": "3"}
+	root := Root(digests)
+	require.Equal(t, root, Root(map[string]string{"c": "3", "a": "1", "b": "2"}))
+	require.NotEqual(t, root, Root(map[string]string{"a": "1", "b": "2", "d": "3"}))
+	require.NotEqual(t, root, Root(map[string]string{"a": "1", "b": "2", "c": "4"}))
+	require.NotEqual(t, Root(nil), root)
+}
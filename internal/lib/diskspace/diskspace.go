@@ -0,0 +1,6 @@
+package diskspace
+
+import "errors"
+
+// ErrUnsupported is returned by Available on platforms where free space cannot be queried.
+var ErrUnsupported = errors.New("free space is not available on this platform")
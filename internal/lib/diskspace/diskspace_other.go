@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package diskspace
+
+// Available returns the number of bytes available to unprivileged users
+// on the filesystem containing path.
+func Available(path string) (int64, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package diskspace
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Available returns the number of bytes available to unprivileged users
+// on the filesystem containing path.
+func Available(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to statfs %s: %w", path, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
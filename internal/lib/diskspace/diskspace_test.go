@@ -0,0 +1,17 @@
+package diskspace
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvailable(t *testing.T) {
+	available, err := Available(t.TempDir())
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	require.Positive(t, available)
+}
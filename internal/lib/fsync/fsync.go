@@ -0,0 +1,42 @@
+package fsync
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// File flushes the contents of the file at path to stable storage.
+func File(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return nil
+}
+
+// Dir flushes the entries of the directory at path, so that files created,
+// renamed or removed in it survive a power loss.
+func Dir(path string) error {
+	return File(path)
+}
+
+// Tree flushes every regular file and directory under root, root included.
+// Symlinks are not followed.
+func Tree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk filepath: %w", err)
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		return File(path)
+	})
+}
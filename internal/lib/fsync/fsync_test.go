@@ -0,0 +1,24 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTree(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b", "c.txt"), []byte("c"), 0644))
+	if err := os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks are unavailable: %v", err)
+	}
+
+	require.NoError(t, Tree(root))
+}
+
+func TestFile_NotExist(t *testing.T) {
+	require.ErrorIs(t, File(filepath.Join(t.TempDir(), "missing")), os.ErrNotExist)
+}
@@ -2,9 +2,12 @@ package locker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/DIvanCode/filestorage/internal/lib/mutex"
+	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
 )
 
 type Locker struct {
@@ -18,7 +21,7 @@ func NewLocker() *Locker {
 func (locker *Locker) ReadLock(ctx context.Context, key any) error {
 	value, _ := locker.locks.LoadOrStore(key, mutex.NewSimpleRWMutex())
 	mutex := value.(*mutex.SimpleRWMutex)
-	return mutex.ReadLock(ctx)
+	return lockError(mutex.ReadLock(ctx))
 }
 
 func (locker *Locker) ReadUnlock(key any) {
@@ -31,7 +34,14 @@ func (locker *Locker) ReadUnlock(key any) {
 func (locker *Locker) WriteLock(ctx context.Context, key any) error {
 	value, _ := locker.locks.LoadOrStore(key, mutex.NewSimpleRWMutex())
 	mutex := value.(*mutex.SimpleRWMutex)
-	return mutex.WriteLock(ctx)
+	return lockError(mutex.WriteLock(ctx))
+}
+
+// TryWriteLock locks key for write only if nobody holds it and reports whether it did.
+func (locker *Locker) TryWriteLock(key any) bool {
+	value, _ := locker.locks.LoadOrStore(key, mutex.NewSimpleRWMutex())
+	mutex := value.(*mutex.SimpleRWMutex)
+	return mutex.TryWriteLock()
 }
 
 func (locker *Locker) WriteUnlock(key any) {
@@ -40,3 +50,11 @@ func (locker *Locker) WriteUnlock(key any) {
 		mutex.WriteUnlock()
 	}
 }
+
+// lockError marks a lock wait that ran out of time, keeping the context error in the chain.
+func lockError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", fserrors.ErrLockTimeout, err)
+	}
+	return err
+}
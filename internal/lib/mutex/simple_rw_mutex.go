@@ -50,6 +50,11 @@ func (rw *SimpleRWMutex) WriteLock(ctx context.Context) error {
 	}
 }
 
+// TryWriteLock locks rw for write without waiting and reports whether it succeeded.
+func (rw *SimpleRWMutex) TryWriteLock() bool {
+	return rw.mu.TryLock()
+}
+
 func (rw *SimpleRWMutex) WriteUnlock() {
 	rw.mu.Unlock()
 }
@@ -0,0 +1,76 @@
+package singleflight
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// Group deduplicates concurrent calls with the same key.
+	Group struct {
+		mu    sync.Mutex
+		calls map[string]*call
+	}
+
+	call struct {
+		done    chan struct{}
+		err     error
+		waiters int
+		cancel  context.CancelFunc
+	}
+)
+
+func NewGroup() *Group {
+	return &Group{
+		calls: make(map[string]*call),
+	}
+}
+
+// Do runs fn once for all concurrent callers with the same key and returns its error to each of them.
+// fn runs with a context that is cancelled only after every caller waiting for it has given up;
+// a caller arriving after that starts a new call.
+// A caller whose ctx is done stops waiting and gets ctx.Err().
+// shared reports whether the call was started by another caller.
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) error) (shared bool, err error) {
+	g.mu.Lock()
+	c, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		g.calls[key] = c
+
+		go func() {
+			c.err = fn(callCtx)
+
+			g.mu.Lock()
+			// an abandoned call is replaced by the next caller's before it returns
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return shared, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// callers arriving from now on must not join a call that is being cancelled
+			delete(g.calls, key)
+			c.cancel()
+		}
+		g.mu.Unlock()
+		return shared, ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestDoSharesResult(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	g := NewGroup()
+	release := make(chan struct{})
+	expected := errors.New("transfer failed")
+	var calls atomic.Int32
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = g.Do(context.Background(), "key", func(context.Context) error {
+				calls.Add(1)
+				<-release
+				return expected
+			})
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c, ok := g.calls["key"]
+		return ok && c.waiters == len(errs)
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls.Load())
+	for _, err := range errs {
+		require.ErrorIs(t, err, expected)
+	}
+}
+
+func TestDoWaiterCancellationKeepsSharedCall(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	g := NewGroup()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var callErr error
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := g.Do(context.Background(), "key", func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				callErr = ctx.Err()
+			}
+			return callErr
+		})
+		result <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	shared, err := g.Do(ctx, "key", func(context.Context) error { return nil })
+	require.True(t, shared)
+	require.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	require.NoError(t, <-result)
+}
+
+func TestDoCancelsCallWhenAllWaitersLeave(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	g := NewGroup()
+	cancelled := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := g.Do(ctx, "key", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("shared call was not cancelled")
+	}
+}
+
+func TestDoStartsNewCallAfterAllWaitersLeave(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	g := NewGroup()
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	abandoned := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer close(abandoned)
+		_, _ = g.Do(ctx, "key", func(ctx context.Context) error {
+			<-ctx.Done()
+			close(cancelled)
+			// the cancelled call is still cleaning up when the next caller arrives
+			<-release
+			return ctx.Err()
+		})
+	}()
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		_, ok := g.calls["key"]
+		return ok
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-cancelled
+	<-abandoned
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shared, err := g.Do(ctx, "key", func(context.Context) error { return nil })
+	require.False(t, shared)
+	require.NoError(t, err)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.calls) == 0
+	}, time.Second, time.Millisecond)
+}
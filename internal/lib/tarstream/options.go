@@ -0,0 +1,125 @@
+package tarstream
+
+import "io"
+
+const (
+	// paxOffset marks an entry that continues a file from the given byte offset.
+	paxOffset = "FILESTORAGE.offset"
+	// paxSHA256 carries the hex encoded SHA-256 of the whole file the entry belongs to.
+	paxSHA256 = "FILESTORAGE.sha256"
+)
+
+type SendOption func(o *sendOptions)
+
+type sendOptions struct {
+	resumeEntry  string
+	resumeOffset int64
+	digests      map[string]string
+	compression  Compression
+}
+
+// WithResume skips every entry before entry and starts entry itself at offset.
+// The entry name is slash-separated and relative to the sent root, as in the archive.
+func WithResume(entry string, offset int64) SendOption {
+	return func(o *sendOptions) {
+		o.resumeEntry = entry
+		o.resumeOffset = offset
+	}
+}
+
+// WithDigests attaches the known SHA-256 digests of files, keyed by their slash-separated
+// path relative to the sent root, so that the receiver verifies what it gets.
+func WithDigests(digests map[string]string) SendOption {
+	return func(o *sendOptions) {
+		o.digests = digests
+	}
+}
+
+// WithCompression compresses the archive with c.
+func WithCompression(c Compression) SendOption {
+	return func(o *sendOptions) {
+		o.compression = c
+	}
+}
+
+func newSendOptions(opts []SendOption) sendOptions {
+	var o sendOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+type ReceiveOption func(o *receiveOptions)
+
+type receiveOptions struct {
+	progress    *Progress
+	sync        bool
+	limits      *Limits
+	compression Compression
+}
+
+// WithProgress records the state of the transfer in p. Passing the same p to the
+// Receive of a resumed stream continues the interrupted one, limits included.
+func WithProgress(p *Progress) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.progress = p
+	}
+}
+
+// WithSync flushes every received file and the directories it was written to
+// to stable storage before Receive returns.
+func WithSync() ReceiveOption {
+	return func(o *receiveOptions) {
+		o.sync = true
+	}
+}
+
+// WithLimits makes Receive enforce limits instead of the default ones.
+// ReceiveWithLimits enforces the limits it is given and ignores this option.
+func WithLimits(limits Limits) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.limits = &limits
+	}
+}
+
+// WithDecompression expects an archive compressed with c. Limits apply to the
+// decompressed archive, so a small stream cannot expand past them.
+func WithDecompression(c Compression) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.compression = c
+	}
+}
+
+func newReceiveOptions(opts []ReceiveOption) receiveOptions {
+	var o receiveOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Progress is how far Receive got. An interrupted transfer is resumed by asking
+// the sender for the rest of the archive starting at Entry and Offset.
+type Progress struct {
+	// Entry is the last entry whose header was received.
+	Entry string
+	// Offset is the number of bytes of Entry written to disk.
+	Offset int64
+
+	entries   int
+	files     int
+	totalSize int64
+	entryEnd  int64
+}
+
+type progressWriter struct {
+	w        io.Writer
+	progress *Progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.progress.Offset += int64(n)
+	return n, err
+}
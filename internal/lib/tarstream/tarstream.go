@@ -2,12 +2,17 @@ package tarstream
 
 import (
 	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/DIvanCode/filestorage/internal/lib/fsync"
 	"github.com/DIvanCode/filestorage/internal/lib/safepath"
 	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
 )
@@ -33,8 +38,13 @@ var defaultLimits = Limits{
 	MaxTotalSize: DefaultMaxTotalSize,
 }
 
+// DefaultLimits returns the limits used by Receive unless WithLimits is given.
+func DefaultLimits() Limits {
+	return defaultLimits
+}
+
 // Send recursively serializes a directory without following symlinks.
-func Send(dir string, w io.Writer) error {
+func Send(dir string, w io.Writer, opts ...SendOption) error {
 	root, err := filepath.Abs(dir)
 	if err != nil {
 		return fmt.Errorf("failed to resolve send root: %w", err)
@@ -42,11 +52,11 @@ func Send(dir string, w io.Writer) error {
 	if err := validateSendRoot(root); err != nil {
 		return err
 	}
-	return send(root, root, false, w)
+	return send(root, root, false, w, opts...)
 }
 
 // SendFile serializes exactly one selected regular file or directory tree.
-func SendFile(file, dir string, w io.Writer) error {
+func SendFile(file, dir string, w io.Writer, opts ...SendOption) error {
 	root, err := filepath.Abs(dir)
 	if err != nil {
 		return fmt.Errorf("failed to resolve send root: %w", err)
@@ -63,10 +73,28 @@ func SendFile(file, dir string, w io.Writer) error {
 		return fmt.Errorf("%w: selected path has unsupported type", fserrors.ErrInvalidPath)
 	}
 
-	return send(root, target, true, w)
+	return send(root, target, true, w, opts...)
 }
 
-func send(root, start string, includeStart bool, w io.Writer) error {
+func send(root, start string, includeStart bool, w io.Writer, opts ...SendOption) error {
+	o := newSendOptions(opts)
+
+	cw, err := newCompressor(w, o.compression)
+	if err != nil {
+		return err
+	}
+	if err := writeTar(root, start, includeStart, cw, o); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("failed to close compressed tarstream: %w", err)
+	}
+	return nil
+}
+
+func writeTar(root, start string, includeStart bool, w io.Writer, o sendOptions) error {
+	skipping := o.resumeEntry != ""
+
 	tw := tar.NewWriter(w)
 	err := filepath.Walk(start, func(path string, info os.FileInfo, walkErr error) error {
 		if walkErr != nil {
@@ -89,6 +117,16 @@ func send(root, start string, includeStart bool, w io.Writer) error {
 			return fmt.Errorf("%w: refusing to send symlink %s", fserrors.ErrInvalidPath, clean)
 		}
 
+		// entries before the resume point have already been received
+		var offset int64
+		if skipping {
+			if filepath.ToSlash(clean) != o.resumeEntry {
+				return nil
+			}
+			skipping = false
+			offset = o.resumeOffset
+		}
+
 		header := &tar.Header{Name: filepath.ToSlash(clean)}
 		switch {
 		case info.IsDir():
@@ -99,9 +137,19 @@ func send(root, start string, includeStart bool, w io.Writer) error {
 			}
 			return nil
 		case info.Mode().IsRegular():
+			if offset > info.Size() {
+				return fmt.Errorf("%w: resume offset %d is beyond the end of %s", fserrors.ErrInvalidArchive, offset, clean)
+			}
 			header.Typeflag = tar.TypeReg
-			header.Size = info.Size()
+			header.Size = info.Size() - offset
 			header.Mode = int64(info.Mode().Perm() & 0755)
+			header.PAXRecords = make(map[string]string)
+			if offset > 0 {
+				header.PAXRecords[paxOffset] = strconv.FormatInt(offset, 10)
+			}
+			if sum, ok := o.digests[header.Name]; ok {
+				header.PAXRecords[paxSHA256] = sum
+			}
 		default:
 			return fmt.Errorf("%w: refusing to send unsupported file type %s", fserrors.ErrInvalidPath, clean)
 		}
@@ -114,7 +162,11 @@ func send(root, start string, includeStart bool, w io.Writer) error {
 		if err != nil {
 			return fmt.Errorf("failed to open file %s: %w", path, err)
 		}
-		_, copyErr := io.Copy(tw, f)
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to seek file %s: %w", path, err)
+		}
+		_, copyErr := io.CopyN(tw, f, header.Size)
 		closeErr := f.Close()
 		if copyErr != nil {
 			return fmt.Errorf("failed to write file %s: %w", path, copyErr)
@@ -128,6 +180,9 @@ func send(root, start string, includeStart bool, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	if skipping {
+		return fmt.Errorf("%w: resume entry %q not found", fserrors.ErrInvalidArchive, o.resumeEntry)
+	}
 	if err := tw.Close(); err != nil {
 		return fmt.Errorf("failed to close tarstream: %w", err)
 	}
@@ -135,11 +190,15 @@ func send(root, start string, includeStart bool, w io.Writer) error {
 }
 
 // Receive materializes a tar stream inside dir using bounded, safe defaults.
-func Receive(dir string, r io.Reader) error {
-	return ReceiveWithLimits(dir, r, defaultLimits)
+func Receive(dir string, r io.Reader, opts ...ReceiveOption) error {
+	limits := defaultLimits
+	if o := newReceiveOptions(opts); o.limits != nil {
+		limits = *o.limits
+	}
+	return ReceiveWithLimits(dir, r, limits, opts...)
 }
 
-func ReceiveWithLimits(dir string, r io.Reader, limits Limits) error {
+func ReceiveWithLimits(dir string, r io.Reader, limits Limits, opts ...ReceiveOption) error {
 	if err := validateLimits(limits); err != nil {
 		return err
 	}
@@ -147,11 +206,28 @@ func ReceiveWithLimits(dir string, r io.Reader, limits Limits) error {
 		return fmt.Errorf("failed to validate destination: %w", err)
 	}
 
-	tr := tar.NewReader(r)
+	o := newReceiveOptions(opts)
+	p := o.progress
+	if p == nil {
+		p = &Progress{}
+	}
+	resumeEntry := p.Entry
+
+	dr, err := newDecompressor(r, o.compression)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read compressed stream: %v", fserrors.ErrInvalidArchive, err)
+	}
+	defer func() { _ = dr.Close() }()
+
+	// limits are checked against the decompressed entries the tar reader yields
+	tr := tar.NewReader(dr)
 	seen := make(map[string]struct{})
-	entries := 0
-	files := 0
-	var totalSize int64
+	// directories whose entries changed, flushed at the end in sync mode
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination: %w", err)
+	}
+	touched := map[string]struct{}{absDir: {}}
 
 	for {
 		header, err := tr.Next()
@@ -162,11 +238,6 @@ func ReceiveWithLimits(dir string, r io.Reader, limits Limits) error {
 			return fmt.Errorf("%w: failed to read tar stream: %v", fserrors.ErrInvalidArchive, err)
 		}
 
-		entries++
-		if entries > limits.MaxEntries {
-			return fmt.Errorf("%w: too many entries", fserrors.ErrArchiveTooLarge)
-		}
-
 		clean, target, err := safepath.Resolve(dir, header.Name)
 		if err != nil {
 			return fmt.Errorf("%w: unsafe entry %q: %v", fserrors.ErrInvalidArchive, header.Name, err)
@@ -174,8 +245,26 @@ func ReceiveWithLimits(dir string, r io.Reader, limits Limits) error {
 		if _, duplicate := seen[clean]; duplicate {
 			return fmt.Errorf("%w: duplicate entry %q", fserrors.ErrInvalidArchive, header.Name)
 		}
+
+		// the first entry of a resumed stream continues the entry that was interrupted
+		resumed := len(seen) == 0 && resumeEntry != "" && filepath.ToSlash(clean) == resumeEntry
 		seen[clean] = struct{}{}
 
+		offset, err := headerOffset(header)
+		if err != nil {
+			return err
+		}
+		if offset > 0 && (!resumed || offset > p.Offset) {
+			return fmt.Errorf("%w: unexpected resume offset for %q", fserrors.ErrInvalidArchive, header.Name)
+		}
+
+		if !resumed {
+			p.entries++
+			if p.entries > limits.MaxEntries {
+				return fmt.Errorf("%w: too many entries", fserrors.ErrArchiveTooLarge)
+			}
+		}
+
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if header.Size != 0 {
@@ -184,18 +273,25 @@ func ReceiveWithLimits(dir string, r io.Reader, limits Limits) error {
 			if err := safepath.MkdirAll(dir, clean, 0755); err != nil {
 				return fmt.Errorf("%w: failed to create directory %q: %v", fserrors.ErrInvalidArchive, header.Name, err)
 			}
+			touchParents(touched, absDir, target)
+			p.Entry, p.Offset, p.entryEnd = filepath.ToSlash(clean), 0, 0
 		case tar.TypeReg, tar.TypeRegA:
-			files++
-			if files > limits.MaxFiles {
-				return fmt.Errorf("%w: too many files", fserrors.ErrArchiveTooLarge)
+			if resumed {
+				// the interrupted attempt has already accounted for this file
+				p.totalSize -= p.entryEnd
+			} else {
+				p.files++
+				if p.files > limits.MaxFiles {
+					return fmt.Errorf("%w: too many files", fserrors.ErrArchiveTooLarge)
+				}
 			}
-			if header.Size < 0 || header.Size > limits.MaxFileSize {
+			if header.Size < 0 || header.Size > limits.MaxFileSize-offset {
 				return fmt.Errorf("%w: file %q is too large", fserrors.ErrArchiveTooLarge, header.Name)
 			}
-			if header.Size > limits.MaxTotalSize-totalSize {
+			if offset+header.Size > limits.MaxTotalSize-p.totalSize {
 				return fmt.Errorf("%w: total file size is too large", fserrors.ErrArchiveTooLarge)
 			}
-			totalSize += header.Size
+			p.totalSize += offset + header.Size
 
 			parent := filepath.Dir(clean)
 			if parent != "." {
@@ -212,30 +308,124 @@ func ReceiveWithLimits(dir string, r io.Reader, limits Limits) error {
 			}
 
 			mode := os.FileMode(header.Mode) & 0755
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			f, err := openReceivedFile(target, mode, offset)
 			if err != nil {
 				return fmt.Errorf("failed to create file %q: %w", header.Name, err)
 			}
-			written, copyErr := io.CopyN(f, tr, header.Size)
+			p.Entry, p.Offset, p.entryEnd = filepath.ToSlash(clean), offset, offset+header.Size
+
+			var w io.Writer = &progressWriter{w: f, progress: p}
+			expectedSum, verify := header.PAXRecords[paxSHA256]
+			h := sha256.New()
+			if verify {
+				w = io.MultiWriter(w, h)
+			}
+			var hashErr error
+			if verify && offset > 0 {
+				hashErr = hashPrefix(f, h, offset)
+			}
+			var written int64
+			var copyErr error
+			if hashErr == nil {
+				written, copyErr = io.CopyN(w, tr, header.Size)
+			}
 			chmodErr := f.Chmod(mode)
+			var syncErr error
+			if o.sync && hashErr == nil && copyErr == nil {
+				syncErr = f.Sync()
+			}
 			closeErr := f.Close()
+			if hashErr != nil {
+				return fmt.Errorf("failed to read resumed file %q: %w", header.Name, hashErr)
+			}
 			if copyErr != nil || written != header.Size {
 				return fmt.Errorf("%w: incomplete data for %q", fserrors.ErrInvalidArchive, header.Name)
 			}
+			if verify && hex.EncodeToString(h.Sum(nil)) != expectedSum {
+				return fmt.Errorf("%w: %q", fserrors.ErrChecksumMismatch, header.Name)
+			}
 			if chmodErr != nil {
 				return fmt.Errorf("failed to set permissions on %q: %w", header.Name, chmodErr)
 			}
+			if syncErr != nil {
+				return fmt.Errorf("failed to sync file %q: %w", header.Name, syncErr)
+			}
 			if closeErr != nil {
 				return fmt.Errorf("failed to close file %q: %w", header.Name, closeErr)
 			}
+			touchParents(touched, absDir, target)
 		default:
 			return fmt.Errorf("%w: unsupported type %d for %q", fserrors.ErrInvalidArchive, header.Typeflag, header.Name)
 		}
 	}
 
+	if o.sync {
+		for path := range touched {
+			if err := fsync.Dir(path); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// touchParents records every directory between path and root, both excluded, as changed.
+func touchParents(touched map[string]struct{}, root, path string) {
+	for parent := filepath.Dir(path); parent != root && parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+		touched[parent] = struct{}{}
+	}
+}
+
+// openReceivedFile opens target for writing; a non-zero offset keeps the data
+// written by an interrupted attempt up to offset.
+func openReceivedFile(target string, mode os.FileMode, offset int64) (*os.File, error) {
+	if offset == 0 {
+		return os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	}
+
+	f, err := os.OpenFile(target, os.O_RDWR, mode)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() < offset {
+		err = fmt.Errorf("%w: resumed file is shorter than offset", fserrors.ErrInvalidArchive)
+	}
+	if err == nil {
+		err = f.Truncate(offset)
+	}
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// hashPrefix feeds the first offset bytes of f, written by an interrupted attempt, to h.
+func hashPrefix(f *os.File, h hash.Hash, offset int64) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(h, f, offset)
+	return err
+}
+
+func headerOffset(header *tar.Header) (int64, error) {
+	value, ok := header.PAXRecords[paxOffset]
+	if !ok {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%w: invalid resume offset for %q", fserrors.ErrInvalidArchive, header.Name)
+	}
+	return offset, nil
+}
+
 func validateSendRoot(root string) error {
 	info, err := os.Lstat(root)
 	if err != nil {
@@ -3,10 +3,13 @@ package tarstream
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
@@ -390,3 +393,214 @@ func TestSendFileDirectoryRoundTrip(t *testing.T) {
 	require.FileExists(t, filepath.Join(to, "selected", "file.txt"))
 	require.NoFileExists(t, filepath.Join(to, "selected-prefix", "leak.txt"))
 }
+
+func TestReceiveResumesInterruptedTransfer(t *testing.T) {
+	from := t.TempDir()
+	to := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(from, "a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a", "first.bin"), bytes.Repeat([]byte("1"), 3000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a", "second.bin"), bytes.Repeat([]byte("2"), 5000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "b.txt"), []byte("bbb"), 0644))
+
+	var full bytes.Buffer
+	require.NoError(t, Send(from, &full))
+
+	var progress Progress
+	interrupted := io.LimitReader(bytes.NewReader(full.Bytes()), 6000)
+	require.ErrorIs(t, Receive(to, interrupted, WithProgress(&progress)), fserrors.ErrInvalidArchive)
+	require.Equal(t, "a/second.bin", progress.Entry)
+	require.Greater(t, progress.Offset, int64(0))
+
+	var rest bytes.Buffer
+	require.NoError(t, Send(from, &rest, WithResume(progress.Entry, progress.Offset)))
+	require.Less(t, rest.Len(), full.Len())
+	require.NoError(t, Receive(to, &rest, WithProgress(&progress)))
+
+	for _, name := range []string{filepath.Join("a", "first.bin"), filepath.Join("a", "second.bin"), "b.txt"} {
+		expected, err := os.ReadFile(filepath.Join(from, name))
+		require.NoError(t, err)
+		actual, err := os.ReadFile(filepath.Join(to, name))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, name)
+	}
+}
+
+func TestSendResumeRejectsUnknownEntry(t *testing.T) {
+	from := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a.txt"), []byte("aaa"), 0644))
+
+	var buf bytes.Buffer
+	require.ErrorIs(t, Send(from, &buf, WithResume("missing.txt", 0)), fserrors.ErrInvalidArchive)
+	require.Empty(t, buf.Bytes())
+}
+
+func TestReceiveRejectsUnexpectedResumeOffset(t *testing.T) {
+	archive := makeTar(t, testTarEntry{
+		header: tar.Header{
+			Name:       "a.txt",
+			Typeflag:   tar.TypeReg,
+			Mode:       0644,
+			PAXRecords: map[string]string{paxOffset: "10"},
+		},
+		data: []byte("tail"),
+	})
+
+	err := Receive(t.TempDir(), bytes.NewReader(archive))
+	require.ErrorIs(t, err, fserrors.ErrInvalidArchive)
+}
+
+func TestReceiveVerifiesDigests(t *testing.T) {
+	from := t.TempDir()
+	content := bytes.Repeat([]byte("abc"), 2000)
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a.txt"), content, 0644))
+	sum := sha256.Sum256(content)
+	digests := map[string]string{"a.txt": hex.EncodeToString(sum[:])}
+
+	var full bytes.Buffer
+	require.NoError(t, Send(from, &full, WithDigests(digests)))
+
+	to := t.TempDir()
+	var progress Progress
+	interrupted := io.LimitReader(bytes.NewReader(full.Bytes()), 3000)
+	require.Error(t, Receive(to, interrupted, WithProgress(&progress)))
+
+	var rest bytes.Buffer
+	require.NoError(t, Send(from, &rest, WithResume(progress.Entry, progress.Offset), WithDigests(digests)))
+	require.NoError(t, Receive(to, &rest, WithProgress(&progress)))
+
+	actual, err := os.ReadFile(filepath.Join(to, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, content, actual)
+}
+
+func TestReceiveRejectsDigestMismatch(t *testing.T) {
+	from := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a.txt"), []byte("corrupted"), 0644))
+	sum := sha256.Sum256([]byte("original"))
+
+	var buf bytes.Buffer
+	require.NoError(t, Send(from, &buf, WithDigests(map[string]string{"a.txt": hex.EncodeToString(sum[:])})))
+
+	err := Receive(t.TempDir(), &buf)
+	require.ErrorIs(t, err, fserrors.ErrChecksumMismatch)
+}
+
+func TestReceiveWithSync(t *testing.T) {
+	from := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(from, "a", "b"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(from, "empty"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a", "b", "c.txt"), []byte("c"), 0644))
+
+	var buf bytes.Buffer
+	require.NoError(t, Send(from, &buf))
+
+	to := t.TempDir()
+	require.NoError(t, Receive(to, &buf, WithSync()))
+
+	content, err := os.ReadFile(filepath.Join(to, "a", "b", "c.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), content)
+	require.DirExists(t, filepath.Join(to, "empty"))
+}
+
+func BenchmarkReceive(b *testing.B) {
+	from := b.TempDir()
+	for i := range 16 {
+		content := bytes.Repeat([]byte{byte(i)}, 64<<10)
+		require.NoError(b, os.WriteFile(filepath.Join(from, strconv.Itoa(i)+".bin"), content, 0644))
+	}
+	var archive bytes.Buffer
+	require.NoError(b, Send(from, &archive))
+
+	for _, bc := range []struct {
+		name string
+		opts []ReceiveOption
+	}{
+		{name: "NoSync"},
+		{name: "Sync", opts: []ReceiveOption{WithSync()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(archive.Len()))
+			for range b.N {
+				to, err := os.MkdirTemp(b.TempDir(), "")
+				require.NoError(b, err)
+				require.NoError(b, Receive(to, bytes.NewReader(archive.Bytes()), bc.opts...))
+			}
+		})
+	}
+}
+
+func TestReceiveWithLimitsOption(t *testing.T) {
+	from := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a.txt"), []byte("abcdef"), 0644))
+
+	var buf bytes.Buffer
+	require.NoError(t, Send(from, &buf))
+
+	limits := DefaultLimits()
+	limits.MaxTotalSize = 5
+	err := Receive(t.TempDir(), bytes.NewReader(buf.Bytes()), WithLimits(limits))
+	require.ErrorIs(t, err, fserrors.ErrArchiveTooLarge)
+
+	limits.MaxTotalSize = 6
+	require.NoError(t, Receive(t.TempDir(), bytes.NewReader(buf.Bytes()), WithLimits(limits)))
+}
+
+func TestCompressedSendReceive(t *testing.T) {
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			from := t.TempDir()
+			content := bytes.Repeat([]byte("build artifact "), 10_000)
+			require.NoError(t, os.MkdirAll(filepath.Join(from, "a"), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(from, "a", "x.bin"), content, 0644))
+
+			var buf bytes.Buffer
+			require.NoError(t, Send(from, &buf, WithCompression(compression)))
+			require.Less(t, buf.Len(), len(content)/10)
+
+			to := t.TempDir()
+			require.NoError(t, Receive(to, &buf, WithDecompression(compression)))
+			received, err := os.ReadFile(filepath.Join(to, "a", "x.bin"))
+			require.NoError(t, err)
+			require.Equal(t, content, received)
+		})
+	}
+}
+
+func TestReceiveEnforcesLimitsOnDecompressedSize(t *testing.T) {
+	archive := makeTar(t, testTarEntry{
+		header: tar.Header{Name: "zeros", Typeflag: tar.TypeReg},
+		data:   make([]byte, 8<<20),
+	})
+	limits := Limits{MaxEntries: 10, MaxFiles: 10, MaxFileSize: 1 << 20, MaxTotalSize: 1 << 20}
+
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newCompressor(&buf, compression)
+			require.NoError(t, err)
+			_, err = w.Write(archive)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			require.Less(t, buf.Len(), 1<<20)
+
+			to := t.TempDir()
+			err = ReceiveWithLimits(to, &buf, limits, WithDecompression(compression))
+			require.ErrorIs(t, err, fserrors.ErrArchiveTooLarge)
+			require.NoFileExists(t, filepath.Join(to, "zeros"))
+		})
+	}
+}
+
+func TestReceiveRejectsCorruptCompressedStream(t *testing.T) {
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			archive := makeTar(t, testTarEntry{
+				header: tar.Header{Name: "a.txt", Typeflag: tar.TypeReg},
+				data:   []byte("aaa"),
+			})
+			err := Receive(t.TempDir(), bytes.NewReader(archive), WithDecompression(compression))
+			require.ErrorIs(t, err, fserrors.ErrInvalidArchive)
+		})
+	}
+}
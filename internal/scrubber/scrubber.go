@@ -0,0 +1,372 @@
+package scrubber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/internal/lib/digest"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/DIvanCode/filestorage/pkg/config"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// Scrubber periodically re-hashes stored buckets and compares them with the
+// digest manifest recorded in their meta at commit time.
+type Scrubber struct {
+	cfg config.ScrubberConfig
+
+	cancelFunc context.CancelFunc
+	done       chan struct{}
+
+	mu      sync.Mutex
+	lastRun *Report
+
+	log *slog.Logger
+}
+
+type FileStorage interface {
+	GetBucket(ctx context.Context, id bucket.ID, extendTTL *time.Duration) (path string, unlock func(), err error)
+	GetBucketMeta(ctx context.Context, id bucket.ID) (BucketMeta, error)
+	QuarantineBucket(ctx context.Context, id bucket.ID) error
+}
+
+// Report describes a single pass over the storage.
+type Report struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Scrubbed is the number of buckets whose contents were verified.
+	Scrubbed int
+	// Skipped is the number of buckets without a digest manifest.
+	Skipped int
+	// Failed is the number of buckets that could not be verified, e.g. because they were locked.
+	Failed int
+	// Bytes is the amount of data read.
+	Bytes     int64
+	Corrupted []Corruption
+}
+
+// Corruption describes a bucket whose contents do not match its manifest.
+type Corruption struct {
+	BucketID bucket.ID
+	// Mismatched, Missing and Unexpected list slash-separated paths relative to the bucket root.
+	Mismatched  []string
+	Missing     []string
+	Unexpected  []string
+	Quarantined bool
+}
+
+func NewScrubber(log *slog.Logger, cfg config.ScrubberConfig) (*Scrubber, error) {
+	if cfg.BytesPerSecond < 0 {
+		return nil, fmt.Errorf("invalid scrubber rate %d", cfg.BytesPerSecond)
+	}
+
+	scrubber := &Scrubber{
+		cfg: cfg,
+
+		log: log,
+	}
+
+	return scrubber, nil
+}
+
+// Start runs a pass every IterationsDelay seconds; it does nothing if the scrubber is disabled.
+func (s *Scrubber) Start(storage FileStorage, rootDir string) {
+	if s.cfg.IterationsDelay <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelFunc = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		delay := time.NewTicker(time.Duration(s.cfg.IterationsDelay) * time.Second)
+		defer delay.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-delay.C:
+				break
+			}
+
+			report, err := s.Scrub(ctx, storage, rootDir)
+			if err != nil {
+				s.log.Error(fmt.Sprintf("error scrubbing: %v", err))
+				continue
+			}
+
+			s.mu.Lock()
+			s.lastRun = &report
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func (s *Scrubber) Stop() {
+	if s.cancelFunc == nil {
+		return
+	}
+	s.cancelFunc()
+	<-s.done
+}
+
+// LastRun returns the report of the last completed pass; ok is false if there was none yet.
+func (s *Scrubber) LastRun() (report Report, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastRun == nil {
+		return Report{}, false
+	}
+	return *s.lastRun, true
+}
+
+// Scrub verifies every bucket in the shards under rootDir once.
+func (s *Scrubber) Scrub(ctx context.Context, storage FileStorage, rootDir string) (Report, error) {
+	report := Report{StartedAt: time.Now()}
+	// the rate applies to the whole pass rather than to each bucket
+	limiter := newLimiter(s.cfg.BytesPerSecond)
+
+	shards, err := os.ReadDir(rootDir)
+	if err != nil {
+		return report, err
+	}
+
+	for _, shard := range shards {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		shardDir := filepath.Join(rootDir, shard.Name())
+		buckets, err := os.ReadDir(shardDir)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("error reading shard %s: %v", shardDir, err))
+			continue
+		}
+
+		for _, bucketDir := range buckets {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			var bucketID bucket.ID
+			if err := bucketID.FromString(bucketDir.Name()); err != nil {
+				continue
+			}
+
+			if err := s.scrubBucket(ctx, storage, bucketID, limiter, &report); err != nil {
+				if ctx.Err() != nil {
+					return report, ctx.Err()
+				}
+				report.Failed++
+				s.log.Error(fmt.Sprintf("error scrubbing bucket %s: %v", bucketID, err))
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (s *Scrubber) scrubBucket(
+	ctx context.Context,
+	storage FileStorage,
+	id bucket.ID,
+	limiter *limiter,
+	report *Report,
+) error {
+	corruption, verified, err := s.verifyBucket(ctx, storage, id, limiter, false, report)
+	if errors.Is(err, ErrBucketNotFound) {
+		return nil
+	}
+	if err == nil && !verified {
+		report.Skipped++
+		return nil
+	}
+	if err == nil && corruption == nil {
+		report.Scrubbed++
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// the first pass ran without the lock, so the bucket may have changed under it; a concurrent
+	// ReserveFile commit may also be caught between moving the file and updating the manifest,
+	// as files are added under the read lock of the bucket; check again holding the lock
+	corruption, verified, err = s.verifyBucket(ctx, storage, id, limiter, true, report)
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	}
+	if !verified {
+		report.Skipped++
+		return nil
+	}
+	report.Scrubbed++
+	if corruption == nil {
+		return nil
+	}
+
+	s.log.Warn(fmt.Sprintf("bucket %s is corrupted: mismatched %v, missing %v, unexpected %v",
+		id, corruption.Mismatched, corruption.Missing, corruption.Unexpected))
+
+	if s.cfg.Quarantine {
+		if err := storage.QuarantineBucket(ctx, id); err != nil {
+			s.log.Error(fmt.Sprintf("error quarantining bucket %s: %v", id, err))
+		} else {
+			corruption.Quarantined = true
+		}
+	}
+
+	report.Corrupted = append(report.Corrupted, *corruption)
+	return nil
+}
+
+// verifyBucket re-hashes bucket id and compares it with its manifest, which is read under a read lock.
+// The lock is held while hashing only if locked is set, so that writers of the bucket are not kept waiting
+// for a throttled read. verified is false if the bucket has no manifest.
+func (s *Scrubber) verifyBucket(
+	ctx context.Context,
+	storage FileStorage,
+	id bucket.ID,
+	limiter *limiter,
+	locked bool,
+	report *Report,
+) (corruption *Corruption, verified bool, err error) {
+	path, unlock, err := storage.GetBucket(ctx, id, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get bucket: %w", err)
+	}
+
+	meta, err := storage.GetBucketMeta(ctx, id)
+	if locked {
+		defer unlock()
+	} else {
+		unlock()
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get bucket meta: %w", err)
+	}
+	if meta.Root == "" {
+		return nil, false, nil
+	}
+
+	sum := func(path string) (string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open file %s: %w", path, err)
+		}
+		defer func() { _ = f.Close() }()
+
+		r := &throttledReader{ctx: ctx, r: f, limiter: limiter, read: &report.Bytes}
+		fileSum, err := digest.Reader(r)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		return fileSum, nil
+	}
+
+	digests, err := digest.DirFunc(path, func(rel string) bool { return rel == FileName(id) }, sum)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to compute bucket digests: %w", err)
+	}
+
+	return compare(id, meta.Digests, digests), true, nil
+}
+
+// compare returns nil if actual matches the expected manifest.
+func compare(id bucket.ID, expected, actual map[string]string) *Corruption {
+	corruption := Corruption{BucketID: id}
+	for path, sum := range expected {
+		actualSum, ok := actual[path]
+		switch {
+		case !ok:
+			corruption.Missing = append(corruption.Missing, path)
+		case actualSum != sum:
+			corruption.Mismatched = append(corruption.Mismatched, path)
+		}
+	}
+	for path := range actual {
+		if _, ok := expected[path]; !ok {
+			corruption.Unexpected = append(corruption.Unexpected, path)
+		}
+	}
+
+	if len(corruption.Mismatched) == 0 && len(corruption.Missing) == 0 && len(corruption.Unexpected) == 0 {
+		return nil
+	}
+	sort.Strings(corruption.Mismatched)
+	sort.Strings(corruption.Missing)
+	sort.Strings(corruption.Unexpected)
+	return &corruption
+}
+
+// limiter paces reads to a fixed number of bytes per second; a zero rate means unlimited.
+type limiter struct {
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newLimiter(rate int64) *limiter {
+	return &limiter{rate: rate, start: time.Now()}
+}
+
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.read += int64(n)
+	due := l.start.Add(time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *limiter
+	read    *int64
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	*r.read += int64(n)
+	if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
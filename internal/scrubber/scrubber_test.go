@@ -0,0 +1,222 @@
+package scrubber_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/internal/lib/digest"
+	"github.com/DIvanCode/filestorage/internal/scrubber"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/DIvanCode/filestorage/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newBucketID(t *testing.T, idNum int) bucket.ID {
+	var id bucket.ID
+
+	idStr := strconv.Itoa(idNum)
+	for len(idStr) < len(id) {
+		idStr = "0" + idStr
+	}
+
+	require.NoError(t, id.FromString(idStr))
+	return id
+}
+
+type MockStorage struct {
+	mock.Mock
+}
+
+func (m *MockStorage) GetBucket(ctx context.Context, id bucket.ID, extendTTL *time.Duration) (string, func(), error) {
+	args := m.Called(id)
+	return args.String(0), func() {}, args.Error(1)
+}
+
+func (m *MockStorage) GetBucketMeta(ctx context.Context, id bucket.ID) (BucketMeta, error) {
+	args := m.Called(id)
+	return args.Get(0).(BucketMeta), args.Error(1)
+}
+
+func (m *MockStorage) QuarantineBucket(ctx context.Context, id bucket.ID) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+// createBucket creates a bucket with files under rootDir and returns its path and manifest meta.
+func createBucket(t *testing.T, rootDir string, id bucket.ID, files map[string]string) (string, BucketMeta) {
+	path := filepath.Join(rootDir, id.String()[:2], id.String())
+	require.NoError(t, os.MkdirAll(path, 0755))
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(path, FileName(id)), []byte("{}"), 0600))
+
+	digests, err := digest.Dir(path, func(rel string) bool { return rel == FileName(id) })
+	require.NoError(t, err)
+	return path, BucketMeta{BucketID: id, Digests: digests, Root: digest.Root(digests)}
+}
+
+func newScrubber(t *testing.T, cfg config.ScrubberConfig) *scrubber.Scrubber {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := scrubber.NewScrubber(log, cfg)
+	require.NoError(t, err)
+	return s
+}
+
+func TestScrub_DetectsAndQuarantinesCorruption(t *testing.T) {
+	rootDir := t.TempDir()
+
+	healthyID := newBucketID(t, 1)
+	healthyPath, healthyMeta := createBucket(t, rootDir, healthyID, map[string]string{"a.txt": "a"})
+
+	corruptedID := newBucketID(t, 2)
+	corruptedPath, corruptedMeta := createBucket(t, rootDir, corruptedID, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(corruptedPath, "a.txt"), []byte("rotten"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(corruptedPath, "dir", "b.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(corruptedPath, "c.txt"), []byte("c"), 0644))
+
+	legacyID := newBucketID(t, 3)
+	legacyPath, _ := createBucket(t, rootDir, legacyID, map[string]string{"a.txt": "a"})
+
+	storage := new(MockStorage)
+	storage.On("GetBucket", healthyID).Return(healthyPath, nil)
+	storage.On("GetBucketMeta", healthyID).Return(healthyMeta, nil)
+	storage.On("GetBucket", corruptedID).Return(corruptedPath, nil)
+	storage.On("GetBucketMeta", corruptedID).Return(corruptedMeta, nil)
+	storage.On("QuarantineBucket", corruptedID).Return(nil)
+	storage.On("GetBucket", legacyID).Return(legacyPath, nil)
+	storage.On("GetBucketMeta", legacyID).Return(BucketMeta{BucketID: legacyID}, nil)
+
+	s := newScrubber(t, config.ScrubberConfig{Quarantine: true})
+	report, err := s.Scrub(context.Background(), storage, rootDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Scrubbed)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 0, report.Failed)
+	assert.Equal(t, int64(len("a")+2*(len("rotten")+len("c"))), report.Bytes)
+	require.Len(t, report.Corrupted, 1)
+	assert.Equal(t, scrubber.Corruption{
+		BucketID:    corruptedID,
+		Mismatched:  []string{"a.txt"},
+		Missing:     []string{"dir/b.txt"},
+		Unexpected:  []string{"c.txt"},
+		Quarantined: true,
+	}, report.Corrupted[0])
+
+	storage.AssertNotCalled(t, "QuarantineBucket", healthyID)
+	storage.AssertNotCalled(t, "QuarantineBucket", legacyID)
+}
+
+func TestScrub_ReportsWithoutQuarantine(t *testing.T) {
+	rootDir := t.TempDir()
+
+	id := newBucketID(t, 1)
+	path, meta := createBucket(t, rootDir, id, map[string]string{"a.txt": "a"})
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("b"), 0644))
+
+	storage := new(MockStorage)
+	storage.On("GetBucket", id).Return(path, nil)
+	storage.On("GetBucketMeta", id).Return(meta, nil)
+
+	s := newScrubber(t, config.ScrubberConfig{})
+	report, err := s.Scrub(context.Background(), storage, rootDir)
+	require.NoError(t, err)
+
+	require.Len(t, report.Corrupted, 1)
+	assert.False(t, report.Corrupted[0].Quarantined)
+	storage.AssertNotCalled(t, "QuarantineBucket", id)
+}
+
+func TestScrub_RechecksMismatchUnderLock(t *testing.T) {
+	rootDir := t.TempDir()
+
+	id := newBucketID(t, 1)
+	path, meta := createBucket(t, rootDir, id, map[string]string{"a.txt": "a", "b.txt": "b"})
+	// the first pass reads the manifest before a concurrent commit recorded b.txt in it
+	stale := BucketMeta{BucketID: id, Digests: map[string]string{"a.txt": meta.Digests["a.txt"]}}
+	stale.Root = digest.Root(stale.Digests)
+
+	storage := new(MockStorage)
+	storage.On("GetBucket", id).Return(path, nil)
+	storage.On("GetBucketMeta", id).Return(stale, nil).Once()
+	storage.On("GetBucketMeta", id).Return(meta, nil).Once()
+
+	s := newScrubber(t, config.ScrubberConfig{Quarantine: true})
+	report, err := s.Scrub(context.Background(), storage, rootDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, report.Scrubbed)
+	assert.Empty(t, report.Corrupted)
+	storage.AssertNumberOfCalls(t, "GetBucket", 2)
+	storage.AssertNotCalled(t, "QuarantineBucket", id)
+}
+
+func TestScrub_RateLimited(t *testing.T) {
+	rootDir := t.TempDir()
+
+	id := newBucketID(t, 1)
+	path, meta := createBucket(t, rootDir, id, map[string]string{"a.txt": string(make([]byte, 2048))})
+
+	storage := new(MockStorage)
+	storage.On("GetBucket", id).Return(path, nil)
+	storage.On("GetBucketMeta", id).Return(meta, nil)
+
+	s := newScrubber(t, config.ScrubberConfig{BytesPerSecond: 4096})
+	start := time.Now()
+	report, err := s.Scrub(context.Background(), storage, rootDir)
+	require.NoError(t, err)
+
+	assert.Empty(t, report.Corrupted)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestScrubber_LastRun(t *testing.T) {
+	rootDir := t.TempDir()
+
+	id := newBucketID(t, 1)
+	path, meta := createBucket(t, rootDir, id, map[string]string{"a.txt": "a"})
+
+	storage := new(MockStorage)
+	storage.On("GetBucket", id).Return(path, nil)
+	storage.On("GetBucketMeta", id).Return(meta, nil)
+
+	s := newScrubber(t, config.ScrubberConfig{IterationsDelay: 1})
+	_, ok := s.LastRun()
+	assert.False(t, ok)
+
+	s.Start(storage, rootDir)
+	require.Eventually(t, func() bool {
+		_, ok := s.LastRun()
+		return ok
+	}, 5*time.Second, 50*time.Millisecond)
+	s.Stop()
+
+	report, _ := s.LastRun()
+	assert.Equal(t, 1, report.Scrubbed)
+	assert.Empty(t, report.Corrupted)
+	assert.False(t, report.FinishedAt.Before(report.StartedAt))
+}
+
+func TestScrubber_Disabled(t *testing.T) {
+	s := newScrubber(t, config.ScrubberConfig{})
+	s.Start(new(MockStorage), t.TempDir())
+	s.Stop()
+
+	_, ok := s.LastRun()
+	assert.False(t, ok)
+}
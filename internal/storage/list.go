@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/DIvanCode/filestorage/internal/lib/safepath"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// ListFiles Возвращает файлы и директории бакета bucketID, отсортированные по пути
+// prefix - директория внутри бакета, содержимое которой нужно вернуть (пустая строка - корень бакета);
+// если prefix указывает на файл, возвращается только он
+// recursive - возвращать ли содержимое вложенных директорий
+// Бакет блокируется в режиме на чтение на время обхода; метаинформация бакета и символические ссылки не возвращаются
+func (s *Storage) ListFiles(
+	ctx context.Context,
+	bucketID bucket.ID,
+	prefix string,
+	recursive bool,
+) ([]bucket.Entry, error) {
+	return s.listFiles(ctx, bucketID, prefix, recursive, "", 0)
+}
+
+// ListFilesPage Возвращает до limit файлов и директорий бакета bucketID с путём больше after в порядке возрастания пути
+// Для первой страницы передайте пустой after, для следующей - путь последнего элемента предыдущей страницы
+// Обход останавливается, как только страница заполнена, и не заходит в директории, целиком лежащие до after
+// prefix и recursive - как в ListFiles
+func (s *Storage) ListFilesPage(
+	ctx context.Context,
+	bucketID bucket.ID,
+	prefix string,
+	recursive bool,
+	after string,
+	limit int,
+) ([]bucket.Entry, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit %d", limit)
+	}
+	return s.listFiles(ctx, bucketID, prefix, recursive, after, limit)
+}
+
+// listFiles lists the entries of bucket bucketID with paths greater than after; limit 0 lists all of them.
+func (s *Storage) listFiles(
+	ctx context.Context,
+	bucketID bucket.ID,
+	prefix string,
+	recursive bool,
+	after string,
+	limit int,
+) (entries []bucket.Entry, err error) {
+	if err = s.locker.ReadLock(ctx, bucketID); err != nil {
+		err = fmt.Errorf("failed to read lock bucket: %w", err)
+		return
+	}
+	defer s.locker.ReadUnlock(bucketID)
+
+	meta, _, err := s.readBucketMeta(bucketID)
+	if err != nil {
+		return
+	}
+	bucketPath := s.getAbsPath(bucketID)
+
+	root := ""
+	if prefix != "" && prefix != "." {
+		info, path, statErr := safepath.Lstat(bucketPath, prefix)
+		if statErr != nil {
+			if errors.Is(statErr, os.ErrNotExist) {
+				err = ErrFileNotFound
+			} else {
+				err = fmt.Errorf("failed to validate prefix: %w", statErr)
+			}
+			return
+		}
+		rel, relErr := filepath.Rel(bucketPath, path)
+		if relErr != nil {
+			err = fmt.Errorf("failed to get relative path of %s: %w", path, relErr)
+			return
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == s.getMetaFile(bucketID) {
+			err = ErrFileNotFound
+			return
+		}
+		if !info.IsDir() {
+			if !info.Mode().IsRegular() {
+				err = fmt.Errorf("failed to validate prefix: %w", ErrInvalidPath)
+				return
+			}
+			entries = []bucket.Entry{}
+			if rel > after {
+				entries = append(entries, newEntry(rel, info, meta.Digests))
+			}
+			s.trasher.Touch(bucketID)
+			return
+		}
+		root = rel
+	}
+
+	l := lister{
+		ctx:        ctx,
+		bucketPath: bucketPath,
+		metaFile:   s.getMetaFile(bucketID),
+		digests:    meta.Digests,
+		recursive:  recursive,
+		after:      after,
+		limit:      limit,
+		entries:    make([]bucket.Entry, 0),
+	}
+	if err = l.listDir(root); err != nil {
+		return
+	}
+
+	s.trasher.Touch(bucketID)
+	return l.entries, nil
+}
+
+// lister collects the entries of a bucket in path order.
+type lister struct {
+	ctx        context.Context
+	bucketPath string
+	metaFile   string
+	digests    map[string]string
+	recursive  bool
+	after      string
+	limit      int
+	entries    []bucket.Entry
+}
+
+// listDir appends the entries of directory rel of the bucket, skipping the ones up to l.after,
+// until l.limit of them are collected.
+// A directory and its contents are not adjacent in path order, e.g. "a" < "a.txt" < "a/b.txt",
+// so the contents of a directory are ordered among its siblings by the directory name followed by a slash.
+func (l *lister) listDir(rel string) error {
+	if err := l.ctx.Err(); err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(filepath.Join(l.bucketPath, filepath.FromSlash(rel)))
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", rel, err)
+	}
+
+	type item struct {
+		path     string
+		entry    fs.DirEntry
+		contents bool
+	}
+	items := make([]item, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		path := d.Name()
+		if rel != "" {
+			path = rel + "/" + path
+		}
+		if path == l.metaFile || !(d.IsDir() || d.Type().IsRegular()) {
+			continue
+		}
+		items = append(items, item{path: path, entry: d})
+		if d.IsDir() && l.recursive {
+			items = append(items, item{path: path + "/", entry: d, contents: true})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].path < items[j].path })
+
+	for _, it := range items {
+		if l.limit > 0 && len(l.entries) >= l.limit {
+			return nil
+		}
+
+		if it.contents {
+			// every path in the directory sorts before after
+			if l.after > it.path && !strings.HasPrefix(l.after, it.path) {
+				continue
+			}
+			if err := l.listDir(strings.TrimSuffix(it.path, "/")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if it.path <= l.after {
+			continue
+		}
+		info, err := it.entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to get info of %s: %w", it.path, err)
+		}
+		l.entries = append(l.entries, newEntry(it.path, info, l.digests))
+	}
+	return nil
+}
+
+func newEntry(rel string, info fs.FileInfo, digests map[string]string) bucket.Entry {
+	entry := bucket.Entry{
+		Path:    rel,
+		Type:    bucket.EntryFile,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	if info.IsDir() {
+		entry.Type = bucket.EntryDir
+		entry.Size = 0
+	} else {
+		entry.Digest = digests[rel]
+	}
+	return entry
+}
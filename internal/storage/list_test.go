@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func entryPaths(entries []bucket.Entry) []string {
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	return paths
+}
+
+func Test_ListFiles(t *testing.T) {
+	s := newTestStorage(t)
+
+	id := newBucketID(t, 1)
+	commitBucket(t, s, id, map[string]string{"a.txt": "a", "a/b.txt": "bb", "a/c/d.txt": "ddd"})
+
+	entries, err := s.ListFiles(context.Background(), id, "", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "a.txt", "a/b.txt", "a/c", "a/c/d.txt"}, entryPaths(entries))
+	require.Equal(t, bucket.EntryDir, entries[0].Type)
+	require.Empty(t, entries[0].Digest)
+	require.Equal(t, bucket.EntryFile, entries[2].Type)
+	require.Equal(t, int64(2), entries[2].Size)
+	require.True(t, entries[2].Mode.IsRegular())
+	require.False(t, entries[2].ModTime.IsZero())
+	require.Equal(t, "3b64db95cb55c763391c707108489ae18b4112d783300de38e033b4c98c3deaf", entries[2].Digest)
+
+	entries, err = s.ListFiles(context.Background(), id, "", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "a.txt"}, entryPaths(entries))
+
+	entries, err = s.ListFiles(context.Background(), id, "a", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b.txt", "a/c"}, entryPaths(entries))
+
+	entries, err = s.ListFiles(context.Background(), id, "a/c/d.txt", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/c/d.txt"}, entryPaths(entries))
+
+	_, err = s.ListFiles(context.Background(), id, "missing", false)
+	require.ErrorIs(t, err, ErrFileNotFound)
+	_, err = s.ListFiles(context.Background(), id, s.getMetaFile(id), false)
+	require.ErrorIs(t, err, ErrFileNotFound)
+	_, err = s.ListFiles(context.Background(), id, "../x", false)
+	require.ErrorIs(t, err, ErrInvalidPath)
+	_, err = s.ListFiles(context.Background(), newBucketID(t, 2), "", false)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func Test_ListFilesPage(t *testing.T) {
+	s := newTestStorage(t)
+
+	id := newBucketID(t, 1)
+	commitBucket(t, s, id, map[string]string{
+		"a.txt":     "a",
+		"a/b.txt":   "b",
+		"a/c/d.txt": "d",
+		"a.b/x.txt": "x",
+		"a0":        "0",
+		"b/e.txt":   "e",
+	})
+
+	for _, query := range []struct {
+		prefix    string
+		recursive bool
+	}{{"", true}, {"", false}, {"a", true}, {"a/c/d.txt", false}} {
+		all, err := s.ListFiles(context.Background(), id, query.prefix, query.recursive)
+		require.NoError(t, err)
+		require.True(t, slices.IsSorted(entryPaths(all)))
+
+		for limit := 1; limit <= 3; limit++ {
+			var listed []bucket.Entry
+			after := ""
+			for {
+				page, err := s.ListFilesPage(context.Background(), id, query.prefix, query.recursive, after, limit)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(page), limit)
+				listed = append(listed, page...)
+				if len(page) < limit {
+					break
+				}
+				after = page[len(page)-1].Path
+			}
+			require.Equal(t, entryPaths(all), entryPaths(listed), "%+v limit %d", query, limit)
+		}
+	}
+
+	entries, err := s.ListFiles(context.Background(), id, "", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "a.b", "a.b/x.txt", "a.txt", "a/b.txt", "a/c", "a/c/d.txt", "a0", "b", "b/e.txt"}, entryPaths(entries))
+
+	// a page may start inside a directory
+	page, err := s.ListFilesPage(context.Background(), id, "", true, "a/b.txt", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/c", "a/c/d.txt"}, entryPaths(page))
+
+	_, err = s.ListFilesPage(context.Background(), id, "", true, "", 0)
+	require.Error(t, err)
+}
+
+func Test_ListBucketsPage(t *testing.T) {
+	s := newTestStorage(t)
+
+	var ids []bucket.ID
+	for _, prefix := range []string{"00", "0a", "a0", "a1", "ff"} {
+		var id bucket.ID
+		require.NoError(t, id.FromString(prefix+strings.Repeat("0", len(id)-len(prefix))))
+		ids = append(ids, id)
+	}
+	for i, id := range ids {
+		ttl := time.Hour
+		if i%2 == 1 {
+			ttl = -time.Hour
+		}
+		reserveBucket(t, s, id, ttl)
+	}
+	_, err := s.PinBucket(context.Background(), ids[2], "job", nil)
+	require.NoError(t, err)
+
+	var listed []bucket.ID
+	var after bucket.ID
+	for {
+		page, err := s.ListBucketsPage(context.Background(), after, 2, bucket.Filter{})
+		require.NoError(t, err)
+		listed = append(listed, page...)
+		if len(page) < 2 {
+			break
+		}
+		after = page[len(page)-1]
+	}
+	require.Equal(t, ids, listed)
+
+	page, err := s.ListBucketsPage(context.Background(), bucket.ID{}, 10, bucket.Filter{Prefix: "a"})
+	require.NoError(t, err)
+	require.Equal(t, ids[2:4], page)
+
+	page, err = s.ListBucketsPage(context.Background(), bucket.ID{}, 10, bucket.Filter{Prefix: "0a0"})
+	require.NoError(t, err)
+	require.Equal(t, ids[1:2], page)
+
+	expired := true
+	page, err = s.ListBucketsPage(context.Background(), bucket.ID{}, 10, bucket.Filter{Expired: &expired})
+	require.NoError(t, err)
+	require.Equal(t, []bucket.ID{ids[1], ids[3]}, page)
+
+	page, err = s.ListBucketsPage(context.Background(), bucket.ID{}, 10, bucket.Filter{PinOwner: "job"})
+	require.NoError(t, err)
+	require.Equal(t, []bucket.ID{ids[2]}, page)
+
+	_, err = s.ListBucketsPage(context.Background(), bucket.ID{}, 0, bucket.Filter{})
+	require.Error(t, err)
+}
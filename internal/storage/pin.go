@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// PinBucket Закрепляет бакет id: пока на бакете есть хотя бы одно закрепление, он не удаляется по времени жизни и не вытесняется
+// owner - необязательная метка владельца закрепления
+// lease - длительность закрепления (оставьте nil, чтобы закрепление держалось до вызова UnpinBucket)
+// Возвращает закрепление, по ID которого его нужно снять
+func (s *Storage) PinBucket(
+	ctx context.Context,
+	id bucket.ID,
+	owner string,
+	lease *time.Duration,
+) (pin bucket.Pin, err error) {
+	if err = s.locker.ReadLock(ctx, id); err != nil {
+		err = fmt.Errorf("failed to read lock bucket: %w", err)
+		return
+	}
+	defer s.locker.ReadUnlock(id)
+
+	now := time.Now()
+	pin = bucket.Pin{ID: uuid.New().String(), Owner: owner}
+	if lease != nil {
+		expires := now.Add(*lease)
+		pin.Expires = &expires
+	}
+
+	err = s.updateBucketMeta(id, func(meta *BucketMeta) {
+		meta.Pins = append(meta.ActivePins(now), pin)
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to update bucket meta: %w", err)
+		return
+	}
+
+	return
+}
+
+// UnpinBucket Снимает закрепление pinID с бакета id
+// Если такого закрепления нет или оно уже истекло, возвращается ErrPinNotFound
+func (s *Storage) UnpinBucket(
+	ctx context.Context,
+	id bucket.ID,
+	pinID string,
+) (err error) {
+	if err = s.locker.ReadLock(ctx, id); err != nil {
+		err = fmt.Errorf("failed to read lock bucket: %w", err)
+		return
+	}
+	defer s.locker.ReadUnlock(id)
+
+	found := false
+	err = s.updateBucketMeta(id, func(meta *BucketMeta) {
+		pins := meta.ActivePins(time.Now())
+		meta.Pins = pins[:0]
+		for _, pin := range pins {
+			if pin.ID == pinID {
+				found = true
+				continue
+			}
+			meta.Pins = append(meta.Pins, pin)
+		}
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to update bucket meta: %w", err)
+		return
+	}
+	if !found {
+		err = ErrPinNotFound
+	}
+
+	return
+}
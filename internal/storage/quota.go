@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/DIvanCode/filestorage/internal/lib/diskspace"
+	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// initUsage sums the sizes of all stored buckets. It must run before the storage is used.
+func (s *Storage) initUsage() error {
+	ids, err := s.ListBuckets(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var used int64
+	for _, id := range ids {
+		size, err := s.bucketSize(id)
+		if err != nil {
+			return fmt.Errorf("failed to get size of bucket %s: %w", id, err)
+		}
+		used += size
+	}
+
+	s.usageMu.Lock()
+	s.used = used
+	s.usageMu.Unlock()
+	return nil
+}
+
+// bucketSize returns the size of bucket id, which the caller must have locked.
+func (s *Storage) bucketSize(id bucket.ID) (int64, error) {
+	meta, _, err := s.readBucketMeta(id)
+	if err != nil {
+		return 0, err
+	}
+	if meta.Stats != nil {
+		return meta.Stats.Size, nil
+	}
+
+	stats, err := statTree(s.getAbsPath(id), func(rel string) bool { return rel == s.getMetaFile(id) })
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute bucket stats: %w", err)
+	}
+	return stats.Size, nil
+}
+
+// quotaLimit is the quota bound that limits the budget.
+type quotaLimit int
+
+const (
+	limitNone quotaLimit = iota
+	limitSize
+	limitFreeSpace
+)
+
+// quotaHold is the part of the budget held by a reservation from its admission until it is committed or aborted,
+// so that reservations in flight cannot together outgrow the quota.
+type quotaHold struct {
+	size int64
+	// limit is the quota bound the hold was cut down by, limitNone if it is as large as a transfer may be
+	limit   quotaLimit
+	settled bool
+}
+
+// admit holds the budget for a new reservation, rejecting it if the storage is already full.
+// A reservation may receive up to tarstream.DefaultMaxTotalSize, so that much is held if the budget allows.
+func (s *Storage) admit() (*quotaHold, error) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	budget, limit, err := s.budget()
+	if err != nil {
+		return nil, err
+	}
+	if budget <= 0 {
+		return nil, s.quotaExceeded(limit)
+	}
+
+	hold := &quotaHold{size: tarstream.DefaultMaxTotalSize}
+	if budget < hold.size {
+		hold.size, hold.limit = budget, limit
+	}
+	s.held += hold.size
+	return hold, nil
+}
+
+// budget returns how many more bytes may be held, math.MaxInt64 if there are no bounds,
+// and the bound that limits it. The caller must hold usageMu.
+func (s *Storage) budget() (int64, quotaLimit, error) {
+	budget, limit := int64(math.MaxInt64), limitNone
+
+	if s.quota.MaxSize > 0 {
+		budget, limit = s.quota.MaxSize-s.used-s.held, limitSize
+	}
+
+	if s.quota.MinFreeSpace > 0 {
+		available, err := diskspace.Available(s.rootDir)
+		if errors.Is(err, diskspace.ErrUnsupported) {
+			return budget, limit, nil
+		}
+		if err != nil {
+			return 0, limitNone, fmt.Errorf("failed to get free space: %w", err)
+		}
+		// held bytes are counted as not written yet, some of them may already be in tmp
+		if free := available - s.quota.MinFreeSpace - s.held; free < budget {
+			budget, limit = free, limitFreeSpace
+		}
+	}
+
+	return budget, limit, nil
+}
+
+// quotaExceeded returns the error for a reservation or transfer stopped by limit.
+func (s *Storage) quotaExceeded(limit quotaLimit) error {
+	switch limit {
+	case limitSize:
+		return fmt.Errorf("%w: storage size limit of %d bytes reached", ErrQuotaExceeded, s.quota.MaxSize)
+	case limitFreeSpace:
+		return fmt.Errorf("%w: free space threshold of %d bytes reached", ErrQuotaExceeded, s.quota.MinFreeSpace)
+	default:
+		return ErrQuotaExceeded
+	}
+}
+
+// releaseHold returns what is left of hold to the budget; it does nothing for a settled hold.
+func (s *Storage) releaseHold(hold *quotaHold) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	s.settle(hold)
+}
+
+// settle returns hold to the budget once. The caller must hold usageMu.
+func (s *Storage) settle(hold *quotaHold) {
+	if hold != nil && !hold.settled {
+		s.held -= hold.size
+		hold.settled = true
+	}
+}
+
+// charge accounts size bytes about to be moved into storage in place of hold, which may be nil.
+// The data is already on disk in tmp, so the free space threshold is checked as is.
+func (s *Storage) charge(size int64, hold *quotaHold) error {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	s.settle(hold)
+
+	if s.quota.MinFreeSpace > 0 {
+		available, err := diskspace.Available(s.rootDir)
+		if err != nil && !errors.Is(err, diskspace.ErrUnsupported) {
+			return fmt.Errorf("failed to get free space: %w", err)
+		}
+		if err == nil && available < s.quota.MinFreeSpace {
+			return fmt.Errorf("%w: %d bytes free, %d required", ErrQuotaExceeded, available, s.quota.MinFreeSpace)
+		}
+	}
+
+	// data within its hold always fits, as the hold was part of the budget
+	if s.quota.MaxSize > 0 && s.used+s.held+size > s.quota.MaxSize {
+		return fmt.Errorf("%w: %d of %d bytes used, %d more requested",
+			ErrQuotaExceeded, s.used+s.held, s.quota.MaxSize, size)
+	}
+	s.used += size
+	return nil
+}
+
+// release accounts size bytes that left the storage.
+func (s *Storage) release(size int64) {
+	s.usageMu.Lock()
+	s.used -= size
+	s.usageMu.Unlock()
+}
+
+// Usage Возвращает суммарный размер файлов в storage в байтах
+func (s *Storage) Usage() int64 {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	return s.used
+}
+
+// downloadLimits returns the limits for receiving a download into a reservation holding hold,
+// so that a transfer that cannot fit is stopped early instead of at commit.
+func downloadLimits(hold *quotaHold) tarstream.Limits {
+	limits := tarstream.DefaultLimits()
+	limits.MaxTotalSize = max(min(limits.MaxTotalSize, hold.size), 1)
+	limits.MaxFileSize = min(limits.MaxFileSize, limits.MaxTotalSize)
+	return limits
+}
+
+// quotaError reports a download stopped by the limits of hold as exceeding the quota bound that cut the hold down.
+func (s *Storage) quotaError(err error, hold *quotaHold) error {
+	if errors.Is(err, ErrArchiveTooLarge) && hold.limit != limitNone {
+		return fmt.Errorf("%w: %w", s.quotaExceeded(hold.limit), err)
+	}
+	return err
+}
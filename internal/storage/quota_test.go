@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DIvanCode/filestorage/pkg/config"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func withMaxSize(maxSize int64) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Quota.MaxSize = maxSize
+	}
+}
+
+func sizedFile(size int) map[string]string {
+	return map[string]string{"data.bin": strings.Repeat("a", size)}
+}
+
+func Test_Quota_MaxSize(t *testing.T) {
+	s := newTestStorage(t, withMaxSize(10))
+
+	require.NoError(t, writeBucket(t, s, newBucketID(t, 1), sizedFile(6)))
+	require.ErrorIs(t, writeBucket(t, s, newBucketID(t, 2), sizedFile(6)), ErrQuotaExceeded)
+	_, _, err := s.GetBucket(context.Background(), newBucketID(t, 2), nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+
+	path, commit, _, err := s.ReserveFile(context.Background(), newBucketID(t, 1), "more.bin")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "more.bin"), make([]byte, 4), 0644))
+	require.NoError(t, commit())
+
+	// the storage is full, so nothing new is admitted
+	_, _, _, err = s.ReserveBucket(context.Background(), newBucketID(t, 3), nil)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	_, _, _, err = s.ReserveFile(context.Background(), newBucketID(t, 1), "last.bin")
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// usage is restored from meta on restart
+	s.Shutdown()
+	s = newTestStorage(t, withRootDir(s.tmpDir), withMaxSize(10))
+	_, _, _, err = s.ReserveBucket(context.Background(), newBucketID(t, 3), nil)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+
+	require.NoError(t, s.RemoveBucket(context.Background(), newBucketID(t, 1)))
+	require.NoError(t, writeBucket(t, s, newBucketID(t, 3), sizedFile(10)))
+}
+
+func Test_Quota_DownloadLimits(t *testing.T) {
+	s := newTestStorage(t, withMaxSize(10))
+	require.NoError(t, writeBucket(t, s, newBucketID(t, 1), sizedFile(4)))
+
+	_, hold, _, abort, err := s.reserveBucket(context.Background(), newBucketID(t, 2), nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, abort()) }()
+	require.Equal(t, int64(6), hold.size)
+
+	limits := downloadLimits(hold)
+	require.Equal(t, int64(6), limits.MaxTotalSize)
+	require.Equal(t, int64(6), limits.MaxFileSize)
+
+	err = s.quotaError(ErrArchiveTooLarge, hold)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	require.ErrorContains(t, err, "storage size limit of 10 bytes")
+}
+
+func Test_Quota_HoldsBudgetOfReservations(t *testing.T) {
+	s := newTestStorage(t, withMaxSize(10))
+
+	_, _, abort, err := s.ReserveBucket(context.Background(), newBucketID(t, 1), nil)
+	require.NoError(t, err)
+
+	// the budget is held by the first reservation until it finishes
+	_, _, _, err = s.ReserveBucket(context.Background(), newBucketID(t, 2), nil)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+
+	require.NoError(t, abort())
+	path, commit, _, err := s.ReserveBucket(context.Background(), newBucketID(t, 2), nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "data.bin"), make([]byte, 4), 0644))
+	require.NoError(t, commit())
+
+	// the commit settles the hold to the size actually written
+	require.Equal(t, int64(4), s.Usage())
+	require.NoError(t, writeBucket(t, s, newBucketID(t, 3), sizedFile(6)))
+}
+
+func Test_Quota_RejectedFileLeavesNoDirs(t *testing.T) {
+	s := newTestStorage(t, withMaxSize(10))
+	require.NoError(t, writeBucket(t, s, newBucketID(t, 1), sizedFile(6)))
+
+	path, commit, _, err := s.ReserveFile(context.Background(), newBucketID(t, 1), "dir/sub/more.bin")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "dir", "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "dir", "sub", "more.bin"), make([]byte, 8), 0644))
+	require.ErrorIs(t, commit(), ErrQuotaExceeded)
+
+	_, err = os.Stat(filepath.Join(s.getAbsPath(newBucketID(t, 1)), "dir"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/internal/lib/digest"
+	"github.com/DIvanCode/filestorage/internal/lib/safepath"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// recoveryReport lists what the startup recovery pass did.
+type recoveryReport struct {
+	// RemovedTemp are the names of reservations left in tmp by an unclean shutdown.
+	RemovedTemp []string
+	// Repaired are the buckets whose meta was recreated or completed.
+	Repaired []bucket.ID
+	// Quarantined are the names of bucket directories moved to quarantine.
+	Quarantined []string
+}
+
+// recover brings the storage into a consistent state after an unclean shutdown.
+// It must run before the storage is used. Reservations in tmp never reached storage and are dropped.
+// In storage, a bucket without meta gets a new one built from its contents, files that were moved in
+// by ReserveFile but not recorded in meta are added to it, and buckets that cannot be trusted
+// (undecodable meta, missing files, special files) are quarantined. Contents of buckets that had
+// a ReserveFile in progress are re-hashed; the rest is left to the scrubber.
+func (s *Storage) recover() (recoveryReport, error) {
+	var report recoveryReport
+
+	interrupted, err := s.recoverTmp(&report)
+	if err != nil {
+		return report, err
+	}
+
+	shards, err := os.ReadDir(s.rootDir)
+	if err != nil {
+		return report, fmt.Errorf("failed to read storage root: %w", err)
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+
+		shardPath := filepath.Join(s.rootDir, shard.Name())
+		entries, err := os.ReadDir(shardPath)
+		if err != nil {
+			return report, fmt.Errorf("failed to read storage shard %s: %w", shard.Name(), err)
+		}
+
+		for _, entry := range entries {
+			var id bucket.ID
+			if err := id.FromString(entry.Name()); err != nil || s.getAbsPath(id) != filepath.Join(shardPath, entry.Name()) {
+				s.log.Warn(fmt.Sprintf("recovery: unexpected entry %s in storage", filepath.Join(shardPath, entry.Name())))
+				continue
+			}
+
+			if err := s.recoverBucket(id, interrupted[id], &report); err != nil {
+				return report, fmt.Errorf("failed to recover bucket %s: %w", id, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// recoverTmp removes everything left in tmp and returns the buckets that had a ReserveFile in progress.
+func (s *Storage) recoverTmp(report *recoveryReport) (map[bucket.ID]bool, error) {
+	interrupted := make(map[bucket.ID]bool)
+
+	entries, err := os.ReadDir(s.tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read temp directory: %w", err)
+	}
+	for _, entry := range entries {
+		if idStr, _, ok := strings.Cut(entry.Name(), "_"); ok {
+			var id bucket.ID
+			if err := id.FromString(idStr); err == nil {
+				interrupted[id] = true
+			}
+		}
+
+		if err := os.RemoveAll(filepath.Join(s.tmpDir, entry.Name())); err != nil {
+			return nil, fmt.Errorf("failed to remove temp directory: %w", err)
+		}
+		report.RemovedTemp = append(report.RemovedTemp, entry.Name())
+		s.log.Warn(fmt.Sprintf("recovery: removed unfinished reservation %s", entry.Name()))
+	}
+
+	return interrupted, nil
+}
+
+func (s *Storage) recoverBucket(id bucket.ID, interrupted bool, report *recoveryReport) error {
+	path := s.getAbsPath(id)
+
+	quarantine := func(reason string) error {
+		target, err := s.moveToQuarantine(path, id)
+		if err != nil {
+			return err
+		}
+		report.Quarantined = append(report.Quarantined, filepath.Base(target))
+		s.log.Warn(fmt.Sprintf("recovery: bucket %s quarantined to %s: %s", id, target, reason))
+		return nil
+	}
+
+	if _, err := s.getSafeBucketPath(id); err != nil {
+		if errors.Is(err, ErrInvalidPath) {
+			return quarantine("not a directory")
+		}
+		return err
+	}
+
+	files, err := listFiles(path, s.getMetaFile(id))
+	if err != nil {
+		if errors.Is(err, ErrInvalidPath) {
+			return quarantine(err.Error())
+		}
+		return err
+	}
+
+	metaInfo, metaPath, err := safepath.Lstat(path, s.getMetaFile(id))
+	if errors.Is(err, os.ErrNotExist) {
+		digests, err := digest.Dir(path, func(rel string) bool { return rel == s.getMetaFile(id) })
+		if err != nil {
+			return fmt.Errorf("failed to compute bucket digests: %w", err)
+		}
+		stats, err := statTree(path, func(rel string) bool { return rel == s.getMetaFile(id) })
+		if err != nil {
+			return fmt.Errorf("failed to compute bucket stats: %w", err)
+		}
+		bucketMeta := BucketMeta{BucketID: id, Digests: digests, Root: digest.Root(digests), Stats: &stats}
+		if err := s.writeMeta(filepath.Join(path, s.getMetaFile(id)), bucketMeta); err != nil {
+			return err
+		}
+		report.Repaired = append(report.Repaired, id)
+		s.log.Warn(fmt.Sprintf("recovery: bucket %s had no meta, recreated it without ttl", id))
+		return nil
+	}
+	if errors.Is(err, ErrInvalidPath) || (err == nil && !metaInfo.Mode().IsRegular()) {
+		return quarantine("meta is not a regular file")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect bucket meta file: %w", err)
+	}
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return fmt.Errorf("failed to read bucket meta file: %w", err)
+	}
+	bucketMeta, err := Unmarshal(data)
+	if errors.Is(err, ErrUnsupportedVersion) {
+		return fmt.Errorf("bucket meta was written by a newer version: %w", err)
+	}
+	if err != nil {
+		return quarantine(fmt.Sprintf("undecodable meta: %v", err))
+	}
+
+	if bucketMeta.BucketID != id {
+		return quarantine(fmt.Sprintf("meta belongs to bucket %s", bucketMeta.BucketID))
+	}
+	if bucketMeta.Root == "" {
+		// buckets committed before digests were recorded have nothing to check against
+		return nil
+	}
+
+	for file := range bucketMeta.Digests {
+		if !files[file] {
+			return quarantine(fmt.Sprintf("file %s is missing", file))
+		}
+	}
+	if interrupted {
+		for file, expected := range bucketMeta.Digests {
+			actual, err := digest.File(filepath.Join(path, filepath.FromSlash(file)))
+			if err != nil {
+				return err
+			}
+			if actual != expected {
+				return quarantine(fmt.Sprintf("file %s does not match its digest", file))
+			}
+		}
+	}
+
+	// files only reach storage once fully received, so an unrecorded file is a ReserveFile
+	// commit interrupted before updating meta
+	repaired := false
+	for file := range files {
+		if _, ok := bucketMeta.Digests[file]; ok {
+			continue
+		}
+		sum, err := digest.File(filepath.Join(path, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		if bucketMeta.Digests == nil {
+			bucketMeta.Digests = make(map[string]string)
+		}
+		bucketMeta.Digests[file] = sum
+		repaired = true
+	}
+	if !repaired {
+		return nil
+	}
+
+	bucketMeta.Root = digest.Root(bucketMeta.Digests)
+	if bucketMeta.Stats != nil {
+		stats, err := statTree(path, func(rel string) bool { return rel == s.getMetaFile(id) })
+		if err != nil {
+			return fmt.Errorf("failed to compute bucket stats: %w", err)
+		}
+		bucketMeta.Stats = &stats
+	}
+	if err := s.writeMeta(metaPath, bucketMeta); err != nil {
+		return err
+	}
+	report.Repaired = append(report.Repaired, id)
+	s.log.Warn(fmt.Sprintf("recovery: recorded unfinished file commits in meta of bucket %s", id))
+	return nil
+}
+
+// listFiles returns the slash-separated paths of the regular files under root except skip.
+// Symlinks and other special files are rejected.
+func listFiles(root, skip string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return fmt.Errorf("failed to walk filepath: %w", walkErr)
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path of %s: %w", path, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." || rel == skip || d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%w: unsupported file type %s", ErrInvalidPath, rel)
+		}
+
+		files[rel] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// writeBucket reserves bucket id, fills it with files and commits it.
+func writeBucket(t *testing.T, s *testStorage, id bucket.ID, files map[string]string) error {
+	path, commit, abort, err := s.ReserveBucket(context.Background(), id, nil)
+	if err != nil {
+		return err
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+	}
+	if err := commit(); err != nil {
+		_ = abort()
+		return err
+	}
+	return nil
+}
+
+func commitBucket(t *testing.T, s *testStorage, id bucket.ID, files map[string]string) string {
+	require.NoError(t, writeBucket(t, s, id, files))
+	return s.getAbsPath(id)
+}
+
+func Test_Recover(t *testing.T) {
+	s := newTestStorage(t)
+	rootDir := s.tmpDir
+
+	healthyID := newBucketID(t, 1)
+	commitBucket(t, s, healthyID, map[string]string{"a.txt": "a"})
+
+	noMetaID := newBucketID(t, 2)
+	noMetaPath := commitBucket(t, s, noMetaID, map[string]string{"a.txt": "a"})
+	require.NoError(t, os.Remove(filepath.Join(noMetaPath, s.getMetaFile(noMetaID))))
+
+	brokenMetaID := newBucketID(t, 3)
+	brokenMetaPath := commitBucket(t, s, brokenMetaID, map[string]string{"a.txt": "a"})
+	require.NoError(t, os.WriteFile(filepath.Join(brokenMetaPath, s.getMetaFile(brokenMetaID)), []byte(`{"id":`), 0600))
+
+	missingFileID := newBucketID(t, 4)
+	missingFilePath := commitBucket(t, s, missingFileID, map[string]string{"a.txt": "a", "b/c.txt": "c"})
+	require.NoError(t, os.Remove(filepath.Join(missingFilePath, "b", "c.txt")))
+
+	// ReserveFile moved b.txt into the bucket but crashed before recording it in meta
+	unrecordedID := newBucketID(t, 5)
+	unrecordedPath := commitBucket(t, s, unrecordedID, map[string]string{"a.txt": "a"})
+	require.NoError(t, os.WriteFile(filepath.Join(unrecordedPath, "b.txt"), []byte("b"), 0644))
+
+	// ReserveFile was in progress while a.txt got truncated
+	partialID := newBucketID(t, 6)
+	partialPath := commitBucket(t, s, partialID, map[string]string{"a.txt": "abc"})
+	require.NoError(t, os.WriteFile(filepath.Join(partialPath, "a.txt"), []byte("a"), 0644))
+
+	s.Shutdown()
+
+	tmpDir := filepath.Join(rootDir, "tmp")
+	reservedID := newBucketID(t, 7)
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, reservedID.String()), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, partialID.String()+"_reservation"), 0755))
+
+	s = newTestStorage(t, withRootDir(rootDir))
+
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	for _, id := range []bucket.ID{healthyID, noMetaID, unrecordedID} {
+		_, unlock, err := s.GetBucket(context.Background(), id, nil)
+		require.NoError(t, err, id.String())
+		unlock()
+	}
+	for _, id := range []bucket.ID{brokenMetaID, missingFileID, partialID} {
+		_, _, err := s.GetBucket(context.Background(), id, nil)
+		require.ErrorIs(t, err, ErrBucketNotFound, id.String())
+	}
+
+	meta, err := s.GetBucketMeta(context.Background(), noMetaID)
+	require.NoError(t, err)
+	require.Equal(t, noMetaID, meta.BucketID)
+	require.Nil(t, meta.TrashTime)
+	require.Contains(t, meta.Digests, "a.txt")
+
+	meta, err = s.GetBucketMeta(context.Background(), unrecordedID)
+	require.NoError(t, err)
+	require.Len(t, meta.Digests, 2)
+	require.Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", meta.Digests["a.txt"])
+	require.Equal(t, "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d", meta.Digests["b.txt"])
+
+	quarantined, err := os.ReadDir(s.quarantineDir)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 3)
+}
+
+func Test_Recover_InterruptedRemoveFile(t *testing.T) {
+	s := newTestStorage(t)
+	rootDir := s.tmpDir
+
+	id := newBucketID(t, 1)
+	path := commitBucket(t, s, id, map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/c.txt": "c"})
+
+	// RemoveFile of dir updated meta and removed dir/b.txt, then crashed
+	require.NoError(t, s.forgetFile(id, "dir", Stats{Size: 2, Files: 2, Dirs: 1}))
+	require.NoError(t, os.Remove(filepath.Join(path, "dir", "b.txt")))
+	s.Shutdown()
+
+	s = newTestStorage(t, withRootDir(rootDir))
+
+	bucketMeta, err := s.GetBucketMeta(context.Background(), id)
+	require.NoError(t, err)
+	require.Len(t, bucketMeta.Digests, 2)
+	require.Contains(t, bucketMeta.Digests, "a.txt")
+	require.Contains(t, bucketMeta.Digests, "dir/c.txt")
+	require.Equal(t, int64(2), bucketMeta.Stats.Size)
+
+	require.NoError(t, s.RemoveFile(context.Background(), id, "dir"))
+	bucketMeta, err = s.GetBucketMeta(context.Background(), id)
+	require.NoError(t, err)
+	require.Len(t, bucketMeta.Digests, 1)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+)
+
+// StatBucket Возвращает размер бакета id, количество файлов и директорий в нём, время его удаления и действующие закрепления
+// Для бакетов, созданных до учёта размеров, они вычисляются при первом вызове и сохраняются в метаинформацию
+func (s *Storage) StatBucket(
+	ctx context.Context,
+	id bucket.ID,
+) (stat bucket.Stat, err error) {
+	if err = s.locker.ReadLock(ctx, id); err != nil {
+		err = fmt.Errorf("failed to read lock bucket: %w", err)
+		return
+	}
+	defer s.locker.ReadUnlock(id)
+
+	meta, _, err := s.readBucketMeta(id)
+	if err != nil {
+		return
+	}
+
+	if meta.Stats == nil {
+		stats, statsErr := statTree(s.getAbsPath(id), func(rel string) bool { return rel == s.getMetaFile(id) })
+		if statsErr != nil {
+			err = fmt.Errorf("failed to compute bucket stats: %w", statsErr)
+			return
+		}
+		meta.Stats = &stats
+
+		err = s.updateBucketMeta(id, func(meta *BucketMeta) {
+			if meta.Stats == nil {
+				meta.Stats = &stats
+			}
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to update bucket meta: %w", err)
+			return
+		}
+	}
+
+	return bucket.Stat{
+		ID:        id,
+		Size:      meta.Stats.Size,
+		FileCount: meta.Stats.Files,
+		DirCount:  meta.Stats.Dirs,
+		TrashTime: meta.TrashTime,
+		Pins:      meta.ActivePins(time.Now()),
+	}, nil
+}
+
+// statTree counts the files, directories and bytes under root, root itself excluded.
+// Paths for which skip returns true are left out.
+func statTree(root string, skip func(rel string) bool) (Stats, error) {
+	var stats Stats
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return fmt.Errorf("failed to walk filepath: %w", walkErr)
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path of %s: %w", path, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." || (skip != nil && skip(rel)) {
+			return nil
+		}
+
+		if d.IsDir() {
+			stats.Dirs++
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to get info of %s: %w", path, err)
+		}
+		stats.Files++
+		stats.Size += info.Size()
+		return nil
+	})
+	return stats, err
+}
@@ -3,20 +3,27 @@ package storage
 import (
 	"context"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
 
 	"github.com/DIvanCode/filestorage/internal/api/client"
+	"github.com/DIvanCode/filestorage/internal/lib/digest"
+	"github.com/DIvanCode/filestorage/internal/lib/fsync"
 	lock "github.com/DIvanCode/filestorage/internal/lib/locker"
 	"github.com/DIvanCode/filestorage/internal/lib/safepath"
+	"github.com/DIvanCode/filestorage/internal/lib/singleflight"
+	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	scrub "github.com/DIvanCode/filestorage/internal/scrubber"
 	trash "github.com/DIvanCode/filestorage/internal/trasher"
 	"github.com/DIvanCode/filestorage/pkg/bucket"
 	"github.com/DIvanCode/filestorage/pkg/config"
@@ -25,11 +32,28 @@ import (
 )
 
 type Storage struct {
-	rootDir string
-	tmpDir  string
+	rootDir       string
+	tmpDir        string
+	quarantineDir string
+	trashDir      string
 
-	trasher *trash.Trasher
-	locker  *lock.Locker
+	durable   bool
+	clientCfg config.ClientConfig
+	// apiPrefix is where the other nodes serve their API, the same as this one
+	apiPrefix string
+
+	gracePeriod time.Duration
+
+	quota   config.QuotaConfig
+	usageMu sync.Mutex
+	used    int64
+	// held is the budget held by reservations in flight
+	held int64
+
+	trasher   *trash.Trasher
+	scrubber  *scrub.Scrubber
+	locker    *lock.Locker
+	downloads *singleflight.Group
 
 	log *slog.Logger
 }
@@ -41,9 +65,6 @@ func NewStorage(log *slog.Logger, cfg config.Config) (*Storage, error) {
 	}
 
 	tmpDir := filepath.Join(configuredRoot, "tmp")
-	if err := os.RemoveAll(tmpDir); err != nil {
-		return nil, err
-	}
 	if err := os.MkdirAll(tmpDir, 0755); err != nil {
 		return nil, err
 	}
@@ -53,19 +74,46 @@ func NewStorage(log *slog.Logger, cfg config.Config) (*Storage, error) {
 		return nil, err
 	}
 
+	quarantineDir := filepath.Join(configuredRoot, "quarantine")
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return nil, err
+	}
+
+	trashDir := filepath.Join(configuredRoot, "trash")
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return nil, err
+	}
+
 	trasher, err := trash.NewTrasher(log, cfg.Trasher)
 	if err != nil {
 		return nil, err
 	}
 
+	scrubber, err := scrub.NewScrubber(log, cfg.Scrubber)
+	if err != nil {
+		return nil, err
+	}
+
 	locker := lock.NewLocker()
 
 	storage := &Storage{
-		rootDir: rootDir,
-		tmpDir:  tmpDir,
+		rootDir:       rootDir,
+		tmpDir:        tmpDir,
+		quarantineDir: quarantineDir,
+		trashDir:      trashDir,
+
+		durable:   cfg.Durable,
+		clientCfg: cfg.Client,
+		apiPrefix: cfg.API.Prefix,
+
+		gracePeriod: time.Duration(cfg.Trasher.GracePeriod) * time.Second,
 
-		trasher: trasher,
-		locker:  locker,
+		quota: cfg.Quota,
+
+		trasher:   trasher,
+		scrubber:  scrubber,
+		locker:    locker,
+		downloads: singleflight.NewGroup(),
 
 		log: log,
 	}
@@ -77,13 +125,42 @@ func NewStorage(log *slog.Logger, cfg config.Config) (*Storage, error) {
 		}
 	}
 
+	report, err := storage.recover()
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover storage: %w", err)
+	}
+	if len(report.RemovedTemp) > 0 || len(report.Repaired) > 0 || len(report.Quarantined) > 0 {
+		log.Warn("recovered storage after unclean shutdown",
+			slog.Int("removed_temp", len(report.RemovedTemp)),
+			slog.Int("repaired", len(report.Repaired)),
+			slog.Int("quarantined", len(report.Quarantined)))
+	}
+	if err := storage.initUsage(); err != nil {
+		return nil, fmt.Errorf("failed to compute storage usage: %w", err)
+	}
+
 	trasher.Start(storage, storage.rootDir)
+	scrubber.Start(scrubberStorage{storage}, storage.rootDir)
 
 	return storage, nil
 }
 
+// scrubberStorage gives the scrubber access to buckets without refreshing them for eviction.
+type scrubberStorage struct {
+	*Storage
+}
+
+func (s scrubberStorage) GetBucket(
+	ctx context.Context,
+	id bucket.ID,
+	extendTTL *time.Duration,
+) (string, func(), error) {
+	return s.getBucket(ctx, id, extendTTL)
+}
+
 func (s *Storage) Shutdown() {
 	s.trasher.Stop()
+	s.scrubber.Stop()
 	_ = os.RemoveAll(s.tmpDir)
 }
 
@@ -134,6 +211,105 @@ func (s *Storage) ListBuckets(ctx context.Context) ([]bucket.ID, error) {
 	return buckets, nil
 }
 
+// ListBucketsPage Возвращает до limit бакетов, подходящих под filter, с ID больше after в порядке возрастания ID
+// Для первой страницы передайте нулевой after, для следующей - последний ID предыдущей страницы
+// Шарды обходятся по порядку, поэтому листинг не требует чтения всего storage
+func (s *Storage) ListBucketsPage(
+	ctx context.Context,
+	after bucket.ID,
+	limit int,
+	filter bucket.Filter,
+) ([]bucket.ID, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit %d", limit)
+	}
+
+	shards, err := os.ReadDir(s.rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage root: %w", err)
+	}
+
+	afterStr := string(after[:])
+	buckets := make([]bucket.ID, 0, min(limit, 1024))
+	for _, shard := range shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !shard.IsDir() || shard.Name() < afterStr[:2] {
+			continue
+		}
+		if !strings.HasPrefix(shard.Name(), filter.Prefix) && !strings.HasPrefix(filter.Prefix, shard.Name()) {
+			continue
+		}
+
+		shardPath := filepath.Join(s.rootDir, shard.Name())
+		entries, err := os.ReadDir(shardPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage shard %s: %w", shard.Name(), err)
+		}
+
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			if !entry.IsDir() || entry.Name() <= afterStr || !strings.HasPrefix(entry.Name(), filter.Prefix) {
+				continue
+			}
+
+			var id bucket.ID
+			if err := id.FromString(entry.Name()); err != nil {
+				continue
+			}
+
+			ok, err := s.matchBucket(ctx, id, filter)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			buckets = append(buckets, id)
+			if len(buckets) == limit {
+				return buckets, nil
+			}
+		}
+	}
+
+	return buckets, nil
+}
+
+// matchBucket reports whether bucket id passes the meta based conditions of filter.
+func (s *Storage) matchBucket(ctx context.Context, id bucket.ID, filter bucket.Filter) (bool, error) {
+	if filter.Expired == nil && filter.PinOwner == "" {
+		return true, nil
+	}
+
+	meta, err := s.GetBucketMeta(ctx, id)
+	if errors.Is(err, ErrBucketNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get meta of bucket %s: %w", id, err)
+	}
+
+	now := time.Now()
+	if filter.Expired != nil {
+		expired := meta.TrashTime != nil && !meta.TrashTime.After(now)
+		if expired != *filter.Expired {
+			return false, nil
+		}
+	}
+	if filter.PinOwner != "" {
+		return slices.ContainsFunc(meta.ActivePins(now), func(pin bucket.Pin) bool {
+			return pin.Owner == filter.PinOwner
+		}), nil
+	}
+	return true, nil
+}
+
 // GetBucket Возвращает абсолютный путь бакета id
 // extendTTL - длительность продления жизни бакета (без надобности оставьте nil)
 // Бакет блокируется в режиме на чтение. Для разблокировки необходимо вызвать unlock()
@@ -142,6 +318,19 @@ func (s *Storage) GetBucket(
 	ctx context.Context,
 	id bucket.ID,
 	extendTTL *time.Duration,
+) (path string, unlock func(), err error) {
+	path, unlock, err = s.getBucket(ctx, id, extendTTL)
+	if err == nil {
+		s.trasher.Touch(id)
+	}
+	return
+}
+
+// getBucket is GetBucket without recording the access for eviction.
+func (s *Storage) getBucket(
+	ctx context.Context,
+	id bucket.ID,
+	extendTTL *time.Duration,
 ) (path string, unlock func(), err error) {
 	if err = s.extendTTL(ctx, id, extendTTL); err != nil {
 		err = fmt.Errorf("failed to extend bucket ttl: %w", err)
@@ -249,6 +438,7 @@ func (s *Storage) GetFile(
 		err = fmt.Errorf("failed to validate file path: %w", ErrInvalidPath)
 		return
 	}
+	s.trasher.Touch(bucketID)
 
 	return
 }
@@ -264,6 +454,16 @@ func (s *Storage) ReserveBucket(
 	id bucket.ID,
 	ttl *time.Duration,
 ) (path string, commit, abort func() error, err error) {
+	path, _, commit, abort, err = s.reserveBucket(ctx, id, ttl)
+	return
+}
+
+// reserveBucket is ReserveBucket also returning the budget held for the reservation.
+func (s *Storage) reserveBucket(
+	ctx context.Context,
+	id bucket.ID,
+	ttl *time.Duration,
+) (path string, hold *quotaHold, commit, abort func() error, err error) {
 	bucketUnlocked := false
 	unlockBucket := func() {
 		if !bucketUnlocked {
@@ -285,14 +485,17 @@ func (s *Storage) ReserveBucket(
 		err = ErrBucketAlreadyExists
 		return
 	}
+	if hold, err = s.admit(); err != nil {
+		return
+	}
 
 	path = filepath.Join(s.tmpDir, id.String())
+	var bucketMeta BucketMeta
 	create := func() error {
 		if err = os.MkdirAll(path, 0755); err != nil {
 			return fmt.Errorf("failed to create temp directory: %w", err)
 		}
 
-		var bucketMeta BucketMeta
 		if ttl != nil {
 			trashTime := time.Now().Add(*ttl)
 			bucketMeta = BucketMeta{
@@ -305,22 +508,9 @@ func (s *Storage) ReserveBucket(
 			}
 		}
 
-		var f *os.File
-		f, err = os.OpenFile(filepath.Join(path, s.getMetaFile(id)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
-		if err != nil {
+		if err = s.writeMeta(filepath.Join(path, s.getMetaFile(id)), bucketMeta); err != nil {
 			return fmt.Errorf("failed to create bucket meta: %w", err)
 		}
-		defer func() { _ = f.Close() }()
-
-		var bytes []byte
-		bytes, err = json.Marshal(bucketMeta)
-		if err != nil {
-			return fmt.Errorf("failed to marshal bucket meta: %w", err)
-		}
-
-		if _, err = f.Write(bytes); err != nil {
-			return fmt.Errorf("failed to write bucket meta: %w", err)
-		}
 
 		return nil
 	}
@@ -333,11 +523,13 @@ func (s *Storage) ReserveBucket(
 
 	abort = func() error {
 		defer unlockBucket()
+		s.releaseHold(hold)
 		return remove()
 	}
 
 	commit = func() error {
 		defer unlockBucket()
+		defer s.releaseHold(hold)
 		info, statErr := os.Lstat(path)
 		if statErr != nil {
 			return fmt.Errorf("failed to inspect reserved bucket: %w", statErr)
@@ -345,21 +537,53 @@ func (s *Storage) ReserveBucket(
 		if info.Mode()&os.ModeSymlink != 0 || !info.IsDir() {
 			return fmt.Errorf("failed to inspect reserved bucket: %w", ErrInvalidPath)
 		}
-		metaInfo, _, metaErr := safepath.Lstat(path, s.getMetaFile(id))
+		metaInfo, metaPath, metaErr := safepath.Lstat(path, s.getMetaFile(id))
 		if metaErr != nil {
 			return fmt.Errorf("failed to inspect reserved bucket metadata: %w", metaErr)
 		}
 		if !metaInfo.Mode().IsRegular() {
 			return fmt.Errorf("failed to inspect reserved bucket metadata: %w", ErrInvalidPath)
 		}
+
+		// the meta file may have been overwritten while filling the bucket, e.g. by a download
+		digests, digestErr := digest.Dir(path, func(rel string) bool { return rel == s.getMetaFile(id) })
+		if digestErr != nil {
+			return fmt.Errorf("failed to compute bucket digests: %w", digestErr)
+		}
+		bucketMeta.Digests = digests
+		bucketMeta.Root = digest.Root(digests)
+		stats, statsErr := statTree(path, func(rel string) bool { return rel == s.getMetaFile(id) })
+		if statsErr != nil {
+			return fmt.Errorf("failed to compute bucket stats: %w", statsErr)
+		}
+		bucketMeta.Stats = &stats
+		if err = s.writeMeta(metaPath, bucketMeta); err != nil {
+			return err
+		}
+
+		if s.durable {
+			if err = fsync.Tree(path); err != nil {
+				return fmt.Errorf("failed to sync reserved bucket: %w", err)
+			}
+		}
+		if err = s.charge(stats.Size, hold); err != nil {
+			return err
+		}
 		if err = os.Rename(path, s.getAbsPath(id)); err != nil {
+			s.release(stats.Size)
 			return fmt.Errorf("failed to move bucket to storage: %w", err)
 		}
+		if s.durable {
+			if err = s.syncDirs(s.tmpDir, filepath.Dir(s.getAbsPath(id))); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
 	if err = create(); err != nil {
 		_ = remove()
+		s.releaseHold(hold)
 		err = fmt.Errorf("failed to create bucket: %w", err)
 		return
 	}
@@ -377,6 +601,16 @@ func (s *Storage) ReserveFile(
 	bucketID bucket.ID,
 	file string,
 ) (path string, commit, abort func() error, err error) {
+	path, _, commit, abort, err = s.reserveFile(ctx, bucketID, file)
+	return
+}
+
+// reserveFile is ReserveFile also returning the budget held for the reservation.
+func (s *Storage) reserveFile(
+	ctx context.Context,
+	bucketID bucket.ID,
+	file string,
+) (path string, hold *quotaHold, commit, abort func() error, err error) {
 	file, _, err = safepath.Resolve(s.getAbsPath(bucketID), file)
 	if err != nil {
 		err = fmt.Errorf("failed to validate file path: %w", err)
@@ -428,6 +662,9 @@ func (s *Storage) ReserveFile(
 		err = ErrFileAlreadyExists
 		return
 	}
+	if hold, err = s.admit(); err != nil {
+		return
+	}
 
 	path = filepath.Join(s.tmpDir, bucketID.String()+"_"+uuid.New().String())
 	create := func() error {
@@ -446,12 +683,14 @@ func (s *Storage) ReserveFile(
 	abort = func() error {
 		defer unlockBucket()
 		defer unlockFile()
+		s.releaseHold(hold)
 		return remove()
 	}
 
 	commit = func() error {
 		defer unlockBucket()
 		defer unlockFile()
+		defer s.releaseHold(hold)
 
 		info, srcPath, statErr := safepath.Lstat(path, file)
 		if statErr != nil {
@@ -460,11 +699,21 @@ func (s *Storage) ReserveFile(
 		if !info.IsDir() && !info.Mode().IsRegular() {
 			return fmt.Errorf("failed to inspect reserved file: %w", ErrInvalidPath)
 		}
+		// only file is moved into the bucket, whatever else was received next to it is discarded
+		digests, digestErr := fileDigests(srcPath, file, info)
+		if digestErr != nil {
+			return fmt.Errorf("failed to compute file digests: %w", digestErr)
+		}
+		stats := Stats{Size: info.Size(), Files: 1}
+		if info.IsDir() {
+			var statsErr error
+			if stats, statsErr = statTree(srcPath, nil); statsErr != nil {
+				return fmt.Errorf("failed to compute file stats: %w", statsErr)
+			}
+			stats.Dirs++
+		}
 
 		bucketPath := s.getAbsPath(bucketID)
-		if err = safepath.MkdirAll(bucketPath, filepath.Dir(file), 0755); err != nil {
-			return fmt.Errorf("failed to create subdirectories in storage: %w", err)
-		}
 		if _, _, statErr = safepath.Lstat(bucketPath, file); statErr == nil {
 			return ErrFileAlreadyExists
 		} else if !os.IsNotExist(statErr) {
@@ -474,18 +723,60 @@ func (s *Storage) ReserveFile(
 		if resolveErr != nil {
 			return fmt.Errorf("failed to resolve destination file: %w", resolveErr)
 		}
+		if s.durable {
+			if err = fsync.Tree(srcPath); err != nil {
+				return fmt.Errorf("failed to sync reserved file: %w", err)
+			}
+		}
+		if err = s.charge(stats.Size, hold); err != nil {
+			return err
+		}
+		// the parent directories are created only once the file is certain to be moved in, so that they are counted
+		if err = s.makeParentDirs(bucketID, file, &stats); err != nil {
+			s.release(stats.Size)
+			return err
+		}
 		if err = os.Rename(srcPath, dstPath); err != nil {
+			s.release(stats.Size)
 			return fmt.Errorf("failed to move file to storage: %w", err)
 		}
+		if s.durable {
+			// the rename and the subdirectories created for it are recorded in every parent up to the bucket
+			var dirs []string
+			for dir := filepath.Dir(dstPath); dir != filepath.Dir(bucketPath); dir = filepath.Dir(dir) {
+				dirs = append(dirs, dir)
+			}
+			if err = s.syncDirs(dirs...); err != nil {
+				return err
+			}
+		}
 		if err = os.RemoveAll(path); err != nil {
 			return fmt.Errorf("failed to remove temp directory: %w", err)
 		}
 
+		err = s.updateBucketMeta(bucketID, func(meta *BucketMeta) {
+			if meta.Digests == nil {
+				meta.Digests = make(map[string]string, len(digests))
+			}
+			for file, sum := range digests {
+				meta.Digests[file] = sum
+			}
+			meta.Root = digest.Root(meta.Digests)
+			// stats of older buckets are computed on the first StatBucket
+			if meta.Stats != nil {
+				meta.Stats.Add(stats)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update bucket meta: %w", err)
+		}
+
 		return nil
 	}
 
 	if err = create(); err != nil {
 		_ = remove()
+		s.releaseHold(hold)
 		err = fmt.Errorf("failed to create file: %w", err)
 		return
 	}
@@ -493,6 +784,49 @@ func (s *Storage) ReserveFile(
 	return
 }
 
+// makeParentDirs creates the missing parent directories of file in bucket bucketID and counts them into stats.
+// Commits to the same bucket run under its read lock, so the directories are created under a lock of their own
+// for each of them to be counted once.
+func (s *Storage) makeParentDirs(bucketID bucket.ID, file string, stats *Stats) error {
+	if err := s.locker.WriteLock(context.Background(), s.dirsLockKey(bucketID)); err != nil {
+		return fmt.Errorf("failed to write lock bucket directories: %w", err)
+	}
+	defer s.locker.WriteUnlock(s.dirsLockKey(bucketID))
+
+	bucketPath := s.getAbsPath(bucketID)
+	for dir := filepath.Dir(file); dir != "."; dir = filepath.Dir(dir) {
+		if _, _, statErr := safepath.Lstat(bucketPath, dir); errors.Is(statErr, os.ErrNotExist) {
+			stats.Dirs++
+		}
+	}
+	if err := safepath.MkdirAll(bucketPath, filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create subdirectories in storage: %w", err)
+	}
+	return nil
+}
+
+// fileDigests returns the digests of the reserved file or directory file at srcPath keyed by their paths in the bucket.
+func fileDigests(srcPath, file string, info os.FileInfo) (map[string]string, error) {
+	rel := filepath.ToSlash(file)
+	if !info.IsDir() {
+		sum, err := digest.File(srcPath)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{rel: sum}, nil
+	}
+
+	sums, err := digest.Dir(srcPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string, len(sums))
+	for name, sum := range sums {
+		digests[rel+"/"+name] = sum
+	}
+	return digests, nil
+}
+
 // DownloadBucket Скачивает бакет id с указанного endpoint
 // ttl - время жизни бакета (оставьте nil, если бакет должен жить бессрочно)
 // Если бакет существует, то его время жизни продлевается на ttl
@@ -502,7 +836,50 @@ func (s *Storage) DownloadBucket(
 	id bucket.ID,
 	ttl *time.Duration,
 ) error {
-	path, commit, abort, err := s.ReserveBucket(ctx, id, ttl)
+	return s.DownloadBucketFrom(ctx, []string{endpoint}, id, ttl)
+}
+
+// DownloadBucketFrom Скачивает бакет id с первого из endpoints, на котором он доступен
+// Endpoints перебираются по порядку; частично скачанные данные отбрасываются перед переходом к следующему
+// Резервирование бакета отменяется, только если скачать бакет не удалось ни с одного endpoint
+// Если бакет не найден ни на одном endpoint, возвращается ErrBucketNotFound
+// ttl - время жизни бакета (оставьте nil, если бакет должен жить бессрочно)
+// Если бакет существует, то его время жизни продлевается на ttl
+// Одновременные скачивания одного бакета объединяются: все вызовы ждут первого и получают его результат
+func (s *Storage) DownloadBucketFrom(
+	ctx context.Context,
+	endpoints []string,
+	id bucket.ID,
+	ttl *time.Duration,
+) error {
+	shared, err := s.downloads.Do(ctx, id.String(), func(ctx context.Context) error {
+		return s.downloadBucketFrom(ctx, endpoints, id, ttl)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the shared download was started with someone else's ttl
+	if shared {
+		if err = s.extendTTL(ctx, id, ttl); err != nil {
+			return fmt.Errorf("failed to extend bucket ttl: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) downloadBucketFrom(
+	ctx context.Context,
+	endpoints []string,
+	id bucket.ID,
+	ttl *time.Duration,
+) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("failed to download bucket: no endpoints given")
+	}
+
+	path, hold, commit, abort, err := s.reserveBucket(ctx, id, ttl)
 	if err != nil && errors.Is(err, ErrBucketAlreadyExists) {
 		if err = s.extendTTL(ctx, id, ttl); err != nil {
 			return fmt.Errorf("failed to extend bucket ttl: %w", err)
@@ -513,10 +890,49 @@ func (s *Storage) DownloadBucket(
 		return fmt.Errorf("failed to reserve bucket: %w", err)
 	}
 
-	c := client.NewClient(endpoint)
-	if err = c.DownloadBucket(ctx, id, path); err != nil {
+	reservedMeta, err := readMetaFile(filepath.Join(path, s.getMetaFile(id)))
+	if err != nil {
 		_ = abort()
-		return fmt.Errorf("failed to download bucket: %w", err)
+		return fmt.Errorf("failed to read reserved bucket meta: %w", err)
+	}
+
+	opts := s.downloadOptions(hold)
+
+	errs := make([]error, 0, len(endpoints))
+	notFound := 0
+	for _, endpoint := range endpoints {
+		if len(errs) > 0 {
+			if err = s.resetReservedBucket(path, id, reservedMeta); err != nil {
+				_ = abort()
+				return fmt.Errorf("failed to reset reserved bucket: %w", err)
+			}
+		}
+
+		err = s.newClient(endpoint).DownloadBucket(ctx, id, path, opts...)
+		if err == nil {
+			break
+		}
+
+		if errors.Is(err, ErrBucketNotFound) {
+			// the bucket may still exist on a peer that failed, so not found is reported only when all agree
+			notFound++
+			s.log.Debug(fmt.Sprintf("bucket %s not found on %s", id.String(), endpoint))
+			errs = append(errs, fmt.Errorf("%s: %v", endpoint, err))
+		} else {
+			s.log.Warn(fmt.Sprintf("failed to download bucket %s from %s: %v", id.String(), endpoint, err))
+			errs = append(errs, fmt.Errorf("%s: %w", endpoint, err))
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		_ = abort()
+		if notFound == len(endpoints) {
+			return fmt.Errorf("failed to download bucket: %w", ErrBucketNotFound)
+		}
+		return fmt.Errorf("failed to download bucket: %w", s.quotaError(errors.Join(errs...), hold))
 	}
 
 	if err = commit(); err != nil {
@@ -535,7 +951,7 @@ func (s *Storage) DownloadFile(
 	bucketID bucket.ID,
 	file string,
 ) error {
-	path, commit, abort, err := s.ReserveFile(ctx, bucketID, file)
+	path, hold, commit, abort, err := s.reserveFile(ctx, bucketID, file)
 	if err != nil && errors.Is(err, ErrFileAlreadyExists) {
 		return nil
 	}
@@ -543,10 +959,12 @@ func (s *Storage) DownloadFile(
 		return fmt.Errorf("failed to reserve file: %w", err)
 	}
 
-	c := client.NewClient(endpoint)
-	if err := c.DownloadFile(ctx, bucketID, file, path); err != nil {
+	opts := s.downloadOptions(hold)
+
+	c := s.newClient(endpoint)
+	if err := c.DownloadFile(ctx, bucketID, file, path, opts...); err != nil {
 		_ = abort()
-		return fmt.Errorf("failed to download file: %w", err)
+		return fmt.Errorf("failed to download file: %w", s.quotaError(err, hold))
 	}
 
 	if err = commit(); err != nil {
@@ -557,6 +975,77 @@ func (s *Storage) DownloadFile(
 	return nil
 }
 
+// UploadBucket Загружает бакет id на указанный endpoint
+// ttl - время жизни бакета на endpoint (оставьте nil, чтобы сохранить время жизни из метаинформации бакета)
+// Бакет с истекшим временем жизни не загружается
+// Бакет блокируется в режиме на чтение на время загрузки
+// Если бакет уже существует на endpoint, то ничего не происходит
+func (s *Storage) UploadBucket(
+	ctx context.Context,
+	endpoint string,
+	id bucket.ID,
+	ttl *time.Duration,
+) error {
+	path, unlock, err := s.GetBucket(ctx, id, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket: %w", err)
+	}
+	defer unlock()
+
+	meta, err := s.GetBucketMeta(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket meta: %w", err)
+	}
+	if ttl == nil && meta.TrashTime != nil {
+		remaining := time.Until(*meta.TrashTime)
+		if remaining <= 0 {
+			return fmt.Errorf("%w: bucket expired at %s", ErrBucketNotFound, meta.TrashTime.Format(time.RFC3339))
+		}
+		ttl = &remaining
+	}
+
+	c := s.newClient(endpoint)
+	if err = c.UploadBucket(ctx, id, path, ttl, tarstream.WithDigests(meta.Digests)); err != nil {
+		if errors.Is(err, ErrBucketAlreadyExists) {
+			return nil
+		}
+		return fmt.Errorf("failed to upload bucket: %w", err)
+	}
+
+	return nil
+}
+
+// UploadFile Загружает файл file из бакета bucketID в существующий бакет на указанном endpoint
+// Бакет и файл блокируются в режиме на чтение на время загрузки
+// Если файл уже существует на endpoint, то ничего не происходит
+func (s *Storage) UploadFile(
+	ctx context.Context,
+	endpoint string,
+	bucketID bucket.ID,
+	file string,
+) error {
+	path, unlock, err := s.GetFile(ctx, bucketID, file, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+	defer unlock()
+
+	meta, err := s.GetBucketMeta(ctx, bucketID)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket meta: %w", err)
+	}
+
+	c := s.newClient(endpoint)
+	if err = c.UploadFile(ctx, bucketID, file, path, tarstream.WithDigests(meta.Digests)); err != nil {
+		if errors.Is(err, ErrFileAlreadyExists) {
+			return nil
+		}
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
+}
+
 // GetBucketMeta Возвращает метаинформацию о бакете id
 func (s *Storage) GetBucketMeta(
 	ctx context.Context,
@@ -570,37 +1059,162 @@ func (s *Storage) GetBucketMeta(
 	}
 	defer unlockBucket()
 
-	path, err := s.getSafeBucketPath(id)
-	if err != nil {
+	meta, _, err = s.readBucketMeta(id)
+	return
+}
+
+// RemoveBucket Удаляет бакет id
+func (s *Storage) RemoveBucket(
+	ctx context.Context,
+	id bucket.ID,
+) (err error) {
+	unlockBucket := func() {
+		s.locker.WriteUnlock(id)
+	}
+	if err = s.locker.WriteLock(ctx, id); err != nil {
+		err = fmt.Errorf("failed to write lock bucket: %w", err)
 		return
 	}
+	defer unlockBucket()
 
-	metaInfo, metaPath, err := safepath.Lstat(path, s.getMetaFile(id))
+	return s.removeBucket(id)
+}
+
+// removeBucket removes bucket id, which the caller must have write locked.
+func (s *Storage) removeBucket(id bucket.ID) error {
+	// a bucket that cannot be measured is still removed
+	size, _ := s.bucketSize(id)
+
+	if err := os.RemoveAll(s.getAbsPath(id)); err != nil {
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
+	s.release(size)
+
+	return nil
+}
+
+// RemoveFile Удаляет файл или директорию file из бакета bucketID
+// Бакет блокируется в режиме на запись, поэтому удаление ждёт завершения чтения бакета и любых файлов в нём
+// Если файла нет, возвращается ErrFileNotFound
+func (s *Storage) RemoveFile(
+	ctx context.Context,
+	bucketID bucket.ID,
+	file string,
+) (err error) {
+	file, _, err = safepath.Resolve(s.getAbsPath(bucketID), file)
 	if err != nil {
-		err = fmt.Errorf("failed to inspect bucket meta file: %w", err)
+		err = fmt.Errorf("failed to validate file path: %w", err)
 		return
 	}
-	if !metaInfo.Mode().IsRegular() {
-		err = fmt.Errorf("failed to inspect bucket meta file: %w", ErrInvalidPath)
+	if filepath.ToSlash(file) == s.getMetaFile(bucketID) {
+		err = fmt.Errorf("failed to validate file path: %w", ErrInvalidPath)
 		return
 	}
-	f, err := os.Open(metaPath)
+
+	// readers of a file lock only its own key, so removing a directory must exclude every reader of the bucket
+	if err = s.locker.WriteLock(ctx, bucketID); err != nil {
+		err = fmt.Errorf("failed to write lock bucket: %w", err)
+		return
+	}
+	defer s.locker.WriteUnlock(bucketID)
+
+	bucketPath, err := s.getSafeBucketPath(bucketID)
+	if err != nil {
+		return
+	}
+	info, path, err := safepath.Lstat(bucketPath, file)
 	if err != nil {
-		err = fmt.Errorf("failed to open bucket meta file: %w", err)
+		if os.IsNotExist(err) {
+			err = ErrFileNotFound
+		} else {
+			err = fmt.Errorf("failed to inspect file: %w", err)
+		}
+		return
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		err = fmt.Errorf("failed to inspect file: %w", ErrInvalidPath)
 		return
 	}
-	defer func() { _ = f.Close() }()
 
-	if err = json.NewDecoder(f).Decode(&meta); err != nil {
-		err = fmt.Errorf("failed to decode bucket meta: %w", err)
+	stats := Stats{Size: info.Size(), Files: 1}
+	if info.IsDir() {
+		if stats, err = statTree(path, nil); err != nil {
+			err = fmt.Errorf("failed to compute file stats: %w", err)
+			return
+		}
+		stats.Dirs++
+	}
+
+	// meta is updated first: a crash before the files are gone leaves them unrecorded,
+	// which recovery records again, while files missing from meta would get the bucket quarantined
+	if err = s.forgetFile(bucketID, filepath.ToSlash(file), stats); err != nil {
+		err = fmt.Errorf("failed to update bucket meta: %w", err)
 		return
 	}
+	s.release(stats.Size)
+
+	if err = os.RemoveAll(path); err != nil {
+		err = fmt.Errorf("failed to remove file: %w", err)
+		return
+	}
+	if s.durable {
+		if err = s.syncDirs(filepath.Dir(path)); err != nil {
+			return
+		}
+	}
 
 	return
 }
 
-// RemoveBucket Удаляет бакет id
-func (s *Storage) RemoveBucket(
+// forgetFile drops file rel, or everything under it, from the meta of bucket bucketID and subtracts their stats.
+func (s *Storage) forgetFile(bucketID bucket.ID, rel string, stats Stats) error {
+	return s.updateBucketMeta(bucketID, func(meta *BucketMeta) {
+		for name := range meta.Digests {
+			if name == rel || strings.HasPrefix(name, rel+"/") {
+				delete(meta.Digests, name)
+			}
+		}
+		if meta.Root != "" {
+			meta.Root = digest.Root(meta.Digests)
+		}
+		if meta.Stats != nil {
+			meta.Stats.Sub(stats)
+		}
+	})
+}
+
+// EvictBucket Удаляет бакет id, если он не заблокирован и не закреплён
+// Бакеты, которые сейчас читаются, резервируются или изменяются, и закреплённые бакеты не удаляются: evicted == false
+func (s *Storage) EvictBucket(
+	ctx context.Context,
+	id bucket.ID,
+) (evicted bool, err error) {
+	if !s.locker.TryWriteLock(id) {
+		return false, nil
+	}
+	defer s.locker.WriteUnlock(id)
+
+	if !s.existsBucket(id) {
+		return false, nil
+	}
+	if meta, _, metaErr := s.readBucketMeta(id); metaErr == nil && meta.Pinned(time.Now()) {
+		return false, nil
+	}
+
+	size, _ := s.bucketSize(id)
+
+	if err = os.RemoveAll(s.getAbsPath(id)); err != nil {
+		err = fmt.Errorf("failed to remove directory: %w", err)
+		return
+	}
+	s.release(size)
+
+	return true, nil
+}
+
+// QuarantineBucket Перемещает бакет id из storage в директорию карантина
+// Бакет остаётся на диске для разбора, но больше не доступен через storage
+func (s *Storage) QuarantineBucket(
 	ctx context.Context,
 	id bucket.ID,
 ) (err error) {
@@ -613,14 +1227,29 @@ func (s *Storage) RemoveBucket(
 	}
 	defer unlockBucket()
 
-	if err = os.RemoveAll(s.getAbsPath(id)); err != nil {
-		err = fmt.Errorf("failed to remove directory: %w", err)
+	path, err := s.getSafeBucketPath(id)
+	if err != nil {
 		return
 	}
 
+	size, _ := s.bucketSize(id)
+
+	target, err := s.moveToQuarantine(path, id)
+	if err != nil {
+		return
+	}
+	s.release(size)
+
+	s.log.Warn(fmt.Sprintf("bucket %s quarantined to %s", id, target))
 	return
 }
 
+// LastScrub Возвращает отчёт о последнем проходе проверки целостности
+// ok == false, если проверка отключена или ещё не завершила ни одного прохода
+func (s *Storage) LastScrub() (report scrub.Report, ok bool) {
+	return s.scrubber.LastRun()
+}
+
 func (s *Storage) extendTTL(
 	ctx context.Context,
 	id bucket.ID,
@@ -635,42 +1264,147 @@ func (s *Storage) extendTTL(
 	}
 	defer s.locker.WriteUnlock(id)
 
-	var bucketMeta BucketMeta
+	trashTime := time.Now().Add(*extendTTL)
+	return s.updateBucketMeta(id, func(meta *BucketMeta) {
+		meta.TrashTime = &trashTime
+	})
+}
 
-	path, err := s.getSafeBucketPath(id)
+// resetReservedBucket discards whatever a failed download left in the reserved bucket
+// and restores its original meta file.
+func (s *Storage) resetReservedBucket(path string, id bucket.ID, meta BucketMeta) error {
+	entries, err := os.ReadDir(path)
 	if err != nil {
 		return err
 	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return s.writeMeta(filepath.Join(path, s.getMetaFile(id)), meta)
+}
+
+// moveToQuarantine moves the directory of bucket id at path to quarantine and returns its new path.
+func (s *Storage) moveToQuarantine(path string, id bucket.ID) (string, error) {
+	target := filepath.Join(s.quarantineDir, fmt.Sprintf("%s.%d", id, time.Now().UnixNano()))
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("failed to move bucket to quarantine: %w", err)
+	}
+	return target, nil
+}
+
+func (s *Storage) newClient(endpoint string) *client.Client {
+	return client.NewClient(endpoint, client.WithRetry(
+		s.clientCfg.Retries,
+		time.Duration(s.clientCfg.RetryInitialDelay)*time.Millisecond,
+		time.Duration(s.clientCfg.RetryMaxDelay)*time.Millisecond,
+	), client.WithPrefix(s.apiPrefix), client.WithToken(s.clientCfg.Token))
+}
+
+// readBucketMeta reads the meta file of bucket id, which the caller must have locked.
+func (s *Storage) readBucketMeta(id bucket.ID) (meta BucketMeta, metaPath string, err error) {
+	path, err := s.getSafeBucketPath(id)
+	if err != nil {
+		return
+	}
+
 	metaInfo, metaPath, err := safepath.Lstat(path, s.getMetaFile(id))
 	if err != nil {
-		return fmt.Errorf("failed to inspect bucket meta: %w", err)
+		err = fmt.Errorf("failed to inspect bucket meta file: %w", err)
+		return
 	}
 	if !metaInfo.Mode().IsRegular() {
-		return fmt.Errorf("failed to inspect bucket meta: %w", ErrInvalidPath)
+		err = fmt.Errorf("failed to inspect bucket meta file: %w", ErrInvalidPath)
+		return
+	}
+	meta, err = readMetaFile(metaPath)
+	return
+}
+
+// readMetaFile reads meta written in any supported format from metaPath.
+func readMetaFile(metaPath string) (BucketMeta, error) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return BucketMeta{}, fmt.Errorf("failed to read bucket meta file: %w", err)
 	}
-	f, err := os.OpenFile(metaPath, os.O_RDWR, 0)
+
+	meta, err := Unmarshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to read bucket meta: %w", err)
+		return BucketMeta{}, fmt.Errorf("failed to decode bucket meta: %w", err)
 	}
-	defer func() { _ = f.Close() }()
+	return meta, nil
+}
 
-	if err = json.NewDecoder(f).Decode(&bucketMeta); err != nil {
-		return fmt.Errorf("failed to unmarshal bucket meta: %w", err)
+// updateBucketMeta applies update to the meta of bucket id, which the caller must have locked at least for read.
+// Concurrent updates of the same bucket are serialized.
+func (s *Storage) updateBucketMeta(id bucket.ID, update func(meta *BucketMeta)) error {
+	if err := s.locker.WriteLock(context.Background(), s.metaLockKey(id)); err != nil {
+		return fmt.Errorf("failed to write lock bucket meta: %w", err)
 	}
+	defer s.locker.WriteUnlock(s.metaLockKey(id))
 
-	trashTime := time.Now().Add(*extendTTL)
-	bucketMeta.TrashTime = &trashTime
+	meta, metaPath, err := s.readBucketMeta(id)
+	if err != nil {
+		return err
+	}
+	update(&meta)
+	return s.writeMeta(metaPath, meta)
+}
 
-	var bytes []byte
-	bytes, err = json.Marshal(bucketMeta)
+// writeMeta replaces the meta file at metaPath with meta. The new meta is written to a temporary
+// file which is then renamed over the old one, so readers and crashes never observe a partial write.
+func (s *Storage) writeMeta(metaPath string, meta BucketMeta) error {
+	bytes, err := Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal bucket meta: %w", err)
 	}
 
-	if _, err = f.Write(bytes); err != nil {
+	f, err := os.CreateTemp(s.tmpDir, "meta-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp bucket meta: %w", err)
+	}
+	tmpPath := f.Name()
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	_, err = f.Write(bytes)
+	if err == nil && s.durable {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		return fmt.Errorf("failed to write bucket meta: %w", err)
 	}
 
+	if err = os.Rename(tmpPath, metaPath); err != nil {
+		return fmt.Errorf("failed to replace bucket meta: %w", err)
+	}
+	if s.durable {
+		return s.syncDirs(filepath.Dir(metaPath))
+	}
+
+	return nil
+}
+
+// downloadOptions returns the options for receiving downloads into a reservation holding hold.
+// In durable mode files are flushed as they arrive, which spreads the cost of the flush at commit.
+func (s *Storage) downloadOptions(hold *quotaHold) []tarstream.ReceiveOption {
+	opts := []tarstream.ReceiveOption{tarstream.WithLimits(downloadLimits(hold))}
+	if s.durable {
+		opts = append(opts, tarstream.WithSync())
+	}
+	return opts
+}
+
+// syncDirs flushes the entries of dirs.
+func (s *Storage) syncDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := fsync.Dir(dir); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -679,7 +1413,7 @@ func (s *Storage) getAbsPath(id bucket.ID) string {
 }
 
 func (s *Storage) getMetaFile(id bucket.ID) string {
-	return id.String()[:] + ".meta.json"
+	return FileName(id)
 }
 
 func (s *Storage) existsBucket(id bucket.ID) bool {
@@ -697,6 +1431,14 @@ func (s *Storage) fileLockKey(bucketID bucket.ID, file string) string {
 	return bucketID.String() + "/" + file
 }
 
+func (s *Storage) metaLockKey(id bucket.ID) string {
+	return id.String() + ":meta"
+}
+
+func (s *Storage) dirsLockKey(id bucket.ID) string {
+	return id.String() + ":dirs"
+}
+
 func (s *Storage) getSafeBucketPath(id bucket.ID) (string, error) {
 	path := s.getAbsPath(id)
 	info, err := os.Lstat(path)
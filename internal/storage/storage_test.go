@@ -2,14 +2,20 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	. "github.com/DIvanCode/filestorage/internal/bucket/meta"
+	"github.com/DIvanCode/filestorage/internal/lib/digest"
 	"github.com/DIvanCode/filestorage/pkg/bucket"
 	"github.com/DIvanCode/filestorage/pkg/config"
 	. "github.com/DIvanCode/filestorage/pkg/errors"
@@ -27,32 +33,44 @@ func (s *testStorage) cleanup() {
 	_ = os.RemoveAll(s.tmpDir)
 }
 
-func newTestStorage(t *testing.T) *testStorage {
-	tmpDir, err := os.MkdirTemp("", "")
-	require.NoError(t, err)
-
-	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+// newTestStorage opens a storage in a new temporary directory with the config adjusted by overrides.
+func newTestStorage(t testing.TB, overrides ...func(cfg *config.Config)) *testStorage {
 	cfg := config.Config{
-		RootDir: tmpDir,
 		Trasher: config.TrasherConfig{
 			Workers:                  1,
 			CollectorIterationsDelay: 60,
 			WorkerIterationsDelay:    60,
 		},
 	}
+	for _, override := range overrides {
+		override(&cfg)
+	}
+	if cfg.RootDir == "" {
+		tmpDir, err := os.MkdirTemp("", "")
+		require.NoError(t, err)
+		cfg.RootDir = tmpDir
+	}
 
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
 	storage, err := NewStorage(log, cfg)
 	if err != nil {
-		_ = os.RemoveAll(tmpDir)
+		_ = os.RemoveAll(cfg.RootDir)
 	}
 	require.NoError(t, err)
 
-	s := &testStorage{Storage: storage, tmpDir: tmpDir}
+	s := &testStorage{Storage: storage, tmpDir: cfg.RootDir}
 	t.Cleanup(s.cleanup)
 	return s
 }
 
-func newBucketID(t *testing.T, idNum int) bucket.ID {
+// withRootDir opens the storage in rootDir, e.g. to reopen a storage that was shut down.
+func withRootDir(rootDir string) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.RootDir = rootDir
+	}
+}
+
+func newBucketID(t testing.TB, idNum int) bucket.ID {
 	var id bucket.ID
 
 	idStr := strconv.Itoa(idNum)
@@ -171,6 +189,7 @@ func Test_GetBucket_WriteLock(t *testing.T) {
 	defer cancel()
 	path, unlock, err := s.GetBucket(ctx, bucketID, nil)
 	require.ErrorIs(t, err, ctx.Err())
+	require.ErrorIs(t, err, ErrLockTimeout)
 	assert.Nil(t, unlock)
 
 	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
@@ -315,6 +334,151 @@ func Test_RemoveBucket_Removed(t *testing.T) {
 	require.ErrorIs(t, err, os.ErrNotExist)
 }
 
+func Test_RemoveFile(t *testing.T) {
+	s := newTestStorage(t)
+
+	bucketID := newBucketID(t, 1)
+	commitBucket(t, s, bucketID, map[string]string{"a.txt": "a", "dir/b.txt": "bb", "dir/c/d.txt": "ddd"})
+	require.Equal(t, int64(6), s.Usage())
+
+	require.NoError(t, s.RemoveFile(context.Background(), bucketID, "a.txt"))
+	require.ErrorIs(t, s.RemoveFile(context.Background(), bucketID, "a.txt"), ErrFileNotFound)
+	require.NoError(t, s.RemoveFile(context.Background(), bucketID, "dir/c"))
+	require.ErrorIs(t, s.RemoveFile(context.Background(), bucketID, s.getMetaFile(bucketID)), ErrInvalidPath)
+	require.ErrorIs(t, s.RemoveFile(context.Background(), bucketID, "../a.txt"), ErrInvalidPath)
+	require.ErrorIs(t, s.RemoveFile(context.Background(), newBucketID(t, 2), "a.txt"), ErrBucketNotFound)
+
+	_, _, err := s.GetFile(context.Background(), bucketID, "a.txt", nil)
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Equal(t, int64(2), s.Usage())
+
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/b.txt"}, slices.Collect(maps.Keys(meta.Digests)))
+	require.Equal(t, &Stats{Size: 2, Files: 1, Dirs: 1}, meta.Stats)
+}
+
+func Test_RemoveFile_WaitsForReaders(t *testing.T) {
+	s := newTestStorage(t)
+
+	bucketID := newBucketID(t, 1)
+	commitBucket(t, s, bucketID, map[string]string{"dir/a.txt": "a"})
+
+	getFile := func() (func(), error) {
+		_, unlock, err := s.GetFile(context.Background(), bucketID, "dir/a.txt", nil)
+		return unlock, err
+	}
+	getBucket := func() (func(), error) {
+		_, unlock, err := s.GetBucket(context.Background(), bucketID, nil)
+		return unlock, err
+	}
+	for name, read := range map[string]func() (func(), error){"file": getFile, "bucket": getBucket} {
+		unlock, err := read()
+		require.NoError(t, err, name)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err = s.RemoveFile(ctx, bucketID, "dir")
+		cancel()
+		require.ErrorIs(t, err, ErrLockTimeout, name)
+		require.FileExists(t, filepath.Join(s.getAbsPath(bucketID), "dir", "a.txt"), name)
+
+		unlock()
+	}
+
+	require.NoError(t, s.RemoveFile(context.Background(), bucketID, "dir"))
+	require.NoDirExists(t, filepath.Join(s.getAbsPath(bucketID), "dir"))
+}
+
+func Test_QuarantineBucket(t *testing.T) {
+	s := newTestStorage(t)
+
+	bucketID := newBucketID(t, 1)
+	reserveBucket(t, s, bucketID, time.Minute)
+
+	require.NoError(t, s.QuarantineBucket(context.Background(), bucketID))
+
+	_, _, err := s.GetBucket(context.Background(), bucketID, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+
+	entries, err := os.ReadDir(s.quarantineDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	_, err = os.Stat(filepath.Join(s.quarantineDir, entries[0].Name(), s.getMetaFile(bucketID)))
+	require.NoError(t, err)
+
+	err = s.QuarantineBucket(context.Background(), bucketID)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func Test_EvictBucket(t *testing.T) {
+	s := newTestStorage(t)
+
+	bucketID := newBucketID(t, 1)
+	path, commit, _, err := s.ReserveBucket(context.Background(), bucketID, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("abc"), 0644))
+	require.NoError(t, commit())
+	require.Equal(t, int64(3), s.Usage())
+
+	_, unlock, err := s.GetBucket(context.Background(), bucketID, nil)
+	require.NoError(t, err)
+	evicted, err := s.EvictBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.False(t, evicted)
+	unlock()
+
+	evicted, err = s.EvictBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.True(t, evicted)
+	require.Equal(t, int64(0), s.Usage())
+
+	_, _, err = s.GetBucket(context.Background(), bucketID, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+
+	evicted, err = s.EvictBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.False(t, evicted)
+}
+
+func Test_PinBucket(t *testing.T) {
+	s := newTestStorage(t)
+
+	bucketID := newBucketID(t, 1)
+	reserveBucket(t, s, bucketID, time.Minute)
+
+	expired := -time.Minute
+	_, err := s.PinBucket(context.Background(), bucketID, "", &expired)
+	require.NoError(t, err)
+	first, err := s.PinBucket(context.Background(), bucketID, "job-1", nil)
+	require.NoError(t, err)
+	second, err := s.PinBucket(context.Background(), bucketID, "job-2", nil)
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, second.ID)
+
+	stat, err := s.StatBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, []bucket.Pin{first, second}, stat.Pins)
+
+	evicted, err := s.EvictBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.False(t, evicted)
+
+	require.NoError(t, s.UnpinBucket(context.Background(), bucketID, first.ID))
+	require.ErrorIs(t, s.UnpinBucket(context.Background(), bucketID, first.ID), ErrPinNotFound)
+
+	evicted, err = s.EvictBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.False(t, evicted)
+
+	require.NoError(t, s.UnpinBucket(context.Background(), bucketID, second.ID))
+	evicted, err = s.EvictBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.True(t, evicted)
+
+	_, err = s.PinBucket(context.Background(), bucketID, "", nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
 func Test_ReserveBucket_Abort(t *testing.T) {
 	s := newTestStorage(t)
 
@@ -490,3 +654,270 @@ func Test_ReserveBucketRejectsSymlinkMetadata(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte(`{"outside":true}`), content)
 }
+
+func Test_CommitRecordsDigests(t *testing.T) {
+	s := newTestStorage(t)
+	bucketID := newBucketID(t, 1)
+	ttl := time.Minute
+
+	path, commit, _, err := s.ReserveBucket(context.Background(), bucketID, &ttl)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("abc"), 0644))
+	require.NoError(t, commit())
+
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"a.txt": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}, meta.Digests)
+	require.NotEmpty(t, meta.Root)
+	bucketRoot := meta.Root
+
+	path, commit, _, err = s.ReserveFile(context.Background(), bucketID, "b/c.txt")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "b", "c.txt"), []byte("abc"), 0644))
+	require.NoError(t, commit())
+
+	meta, err = s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Len(t, meta.Digests, 2)
+	require.Equal(t, meta.Digests["a.txt"], meta.Digests["b/c.txt"])
+	require.NotEqual(t, bucketRoot, meta.Root)
+	require.NotNil(t, meta.TrashTime)
+}
+
+func Test_ReserveFile_IgnoresExtraEntries(t *testing.T) {
+	s := newTestStorage(t)
+	bucketID := newBucketID(t, 1)
+	commitBucket(t, s, bucketID, map[string]string{"a.txt": "a"})
+
+	path, commit, _, err := s.ReserveFile(context.Background(), bucketID, "b.txt")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "b.txt"), []byte("b"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "extra.txt"), []byte("extra"), 0644))
+	require.NoError(t, commit())
+
+	path, commit, _, err = s.ReserveFile(context.Background(), bucketID, "dir")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "dir", "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "dir", "sub", "c.txt"), []byte("c"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "other"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "other", "d.txt"), []byte("d"), 0644))
+	require.NoError(t, commit())
+
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a.txt", "b.txt", "dir/sub/c.txt"}, slices.Collect(maps.Keys(meta.Digests)))
+	require.Equal(t, meta.Root, digest.Root(meta.Digests))
+
+	_, err = os.Stat(filepath.Join(s.getAbsPath(bucketID), "extra.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(s.getAbsPath(bucketID), "other"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// the recorded digests describe the bucket exactly, so recovery keeps it
+	s.Shutdown()
+	reopened := newTestStorage(t, withRootDir(s.tmpDir))
+	_, unlock, err := reopened.GetBucket(context.Background(), bucketID, nil)
+	require.NoError(t, err)
+	unlock()
+}
+
+func Test_DurableCommit(t *testing.T) {
+	s := newTestStorage(t, func(cfg *config.Config) { cfg.Durable = true })
+
+	bucketID := newBucketID(t, 1)
+	path, commit, _, err := s.ReserveBucket(context.Background(), bucketID, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, commit())
+
+	path, commit, _, err = s.ReserveFile(context.Background(), bucketID, "b/c/d.txt")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "b", "c", "d.txt"), []byte("d"), 0644))
+	require.NoError(t, commit())
+
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Len(t, meta.Digests, 2)
+	content, err := os.ReadFile(filepath.Join(s.getAbsPath(bucketID), "b", "c", "d.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("d"), content)
+}
+
+func BenchmarkReserveBucket_Commit(b *testing.B) {
+	content := make([]byte, 64<<10)
+
+	for _, durable := range []bool{false, true} {
+		b.Run("Durable="+strconv.FormatBool(durable), func(b *testing.B) {
+			s := newTestStorage(b, func(cfg *config.Config) { cfg.Durable = durable })
+
+			b.SetBytes(int64(16 * len(content)))
+			b.ResetTimer()
+			for i := range b.N {
+				path, commit, _, err := s.ReserveBucket(context.Background(), newBucketID(b, i), nil)
+				require.NoError(b, err)
+				for j := range 16 {
+					require.NoError(b, os.WriteFile(filepath.Join(path, strconv.Itoa(j)+".bin"), content, 0644))
+				}
+				require.NoError(b, commit())
+			}
+		})
+	}
+}
+
+func BenchmarkReserveFile_Commit(b *testing.B) {
+	content := make([]byte, 64<<10)
+
+	for _, durable := range []bool{false, true} {
+		b.Run("Durable="+strconv.FormatBool(durable), func(b *testing.B) {
+			s := newTestStorage(b, func(cfg *config.Config) { cfg.Durable = durable })
+
+			bucketID := newBucketID(b, 1)
+			_, commit, _, err := s.ReserveBucket(context.Background(), bucketID, nil)
+			require.NoError(b, err)
+			require.NoError(b, commit())
+
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for i := range b.N {
+				file := filepath.Join("dir", strconv.Itoa(i)+".bin")
+				path, commit, _, err := s.ReserveFile(context.Background(), bucketID, file)
+				require.NoError(b, err)
+				require.NoError(b, os.WriteFile(filepath.Join(path, file), content, 0644))
+				require.NoError(b, commit())
+			}
+		})
+	}
+}
+
+func Test_ExtendTTL_RewritesMeta(t *testing.T) {
+	s := newTestStorage(t)
+	bucketID := newBucketID(t, 1)
+	reserveBucket(t, s, bucketID, time.Minute)
+
+	for _, ttl := range []time.Duration{time.Hour, 2 * time.Hour} {
+		_, unlock, err := s.GetBucket(context.Background(), bucketID, &ttl)
+		require.NoError(t, err)
+		unlock()
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.getAbsPath(bucketID), s.getMetaFile(bucketID)))
+	require.NoError(t, err)
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(data, &raw))
+	require.EqualValues(t, CurrentVersion, raw["version"])
+
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.True(t, meta.TrashTime.After(time.Now().Add(time.Hour+time.Minute)))
+
+	entries, err := os.ReadDir(s.Storage.tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func Test_GetBucketMeta_MigratesUnversionedMeta(t *testing.T) {
+	s := newTestStorage(t)
+	bucketID := newBucketID(t, 1)
+	reserveBucket(t, s, bucketID, time.Minute)
+
+	metaPath := filepath.Join(s.getAbsPath(bucketID), s.getMetaFile(bucketID))
+	legacy := `{"id":"` + bucketID.String() + `","trash_time":"2030-01-02T03:04:05Z"}`
+	require.NoError(t, os.WriteFile(metaPath, []byte(legacy), 0600))
+
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, meta.Version)
+	require.Equal(t, bucketID, meta.BucketID)
+	require.Equal(t, 2030, meta.TrashTime.Year())
+
+	ttl := time.Hour
+	_, unlock, err := s.GetBucket(context.Background(), bucketID, &ttl)
+	require.NoError(t, err)
+	unlock()
+
+	data, err := os.ReadFile(metaPath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"version":1`)
+}
+
+func Test_StatBucket(t *testing.T) {
+	s := newTestStorage(t)
+	bucketID := newBucketID(t, 1)
+
+	path, commit, _, err := s.ReserveBucket(context.Background(), bucketID, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "a", "empty"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a", "b.txt"), []byte("bb"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "c.txt"), []byte("ccc"), 0644))
+	require.NoError(t, commit())
+
+	stat, err := s.StatBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, bucket.Stat{ID: bucketID, Size: 5, FileCount: 2, DirCount: 2}, stat)
+
+	path, commit, _, err = s.ReserveFile(context.Background(), bucketID, "a/d/e/f.txt")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "a", "d", "e", "f.txt"), []byte("ffff"), 0644))
+	require.NoError(t, commit())
+
+	path, commit, _, err = s.ReserveFile(context.Background(), bucketID, "g")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "g", "h"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "g", "h", "i.txt"), []byte("i"), 0644))
+	require.NoError(t, commit())
+
+	stat, err = s.StatBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, bucket.Stat{ID: bucketID, Size: 10, FileCount: 4, DirCount: 6}, stat)
+
+	// buckets committed before stats were recorded get them on the first call
+	require.NoError(t, s.updateBucketMeta(bucketID, func(meta *BucketMeta) { meta.Stats = nil }))
+	stat, err = s.StatBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, bucket.Stat{ID: bucketID, Size: 10, FileCount: 4, DirCount: 6}, stat)
+	meta, err := s.GetBucketMeta(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, &Stats{Size: 10, Files: 4, Dirs: 6}, meta.Stats)
+
+	_, err = s.StatBucket(context.Background(), newBucketID(t, 2))
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func Test_StatBucket_ConcurrentCommits(t *testing.T) {
+	s := newTestStorage(t)
+	bucketID := newBucketID(t, 1)
+	commitBucket(t, s, bucketID, nil)
+
+	commits := make([]func() error, 64)
+	for i := range commits {
+		name := filepath.Join("a", "b", "c", "d", "e", strconv.Itoa(i)+".txt")
+		path, commit, _, err := s.ReserveFile(context.Background(), bucketID, name)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte("a"), 0644))
+		commits[i] = commit
+	}
+
+	// every commit creates the missing parent directories, which must be counted once
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, len(commits))
+	for i, commit := range commits {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = commit()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	stat, err := s.StatBucket(context.Background(), bucketID)
+	require.NoError(t, err)
+	require.Equal(t, bucket.Stat{ID: bucketID, Size: 64, FileCount: 64, DirCount: 5}, stat)
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// TrashBucket Удаляет бакет id с истёкшим временем жизни
+// Если задан период ожидания, бакет перемещается в корзину, откуда его можно вернуть через RestoreBucket,
+// и окончательно удаляется только по истечении периода; иначе бакет удаляется сразу
+// Бакет, время жизни которого продлено или который закреплён, не удаляется
+func (s *Storage) TrashBucket(
+	ctx context.Context,
+	id bucket.ID,
+) (err error) {
+	if err = s.locker.WriteLock(ctx, id); err != nil {
+		err = fmt.Errorf("failed to write lock bucket: %w", err)
+		return
+	}
+	defer s.locker.WriteUnlock(id)
+
+	path, err := s.getSafeBucketPath(id)
+	if errors.Is(err, ErrBucketNotFound) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	// the bucket may have been pinned or extended since it was found expired
+	meta, _, err := s.readBucketMeta(id)
+	if err != nil {
+		err = fmt.Errorf("failed to read bucket meta: %w", err)
+		return
+	}
+	now := time.Now()
+	if meta.TrashTime == nil || meta.TrashTime.After(now) || meta.Pinned(now) {
+		return nil
+	}
+
+	if s.gracePeriod <= 0 {
+		return s.removeBucket(id)
+	}
+
+	size, _ := s.bucketSize(id)
+
+	target := filepath.Join(s.trashDir, fmt.Sprintf("%s.%d", id, time.Now().UnixNano()))
+	if err = os.Rename(path, target); err != nil {
+		err = fmt.Errorf("failed to move bucket to trash: %w", err)
+		return
+	}
+	s.release(size)
+
+	return
+}
+
+// RestoreBucket Возвращает в storage бакет id, перемещённый в корзину
+// ttl - новое время жизни бакета (оставьте nil, если бакет должен жить бессрочно)
+// Если бакет был в корзине несколько раз, возвращается последняя копия
+// Если бакета нет в корзине, возвращается ErrBucketNotFound; если он уже есть в storage - ErrBucketAlreadyExists
+func (s *Storage) RestoreBucket(
+	ctx context.Context,
+	id bucket.ID,
+	ttl *time.Duration,
+) (err error) {
+	if err = s.locker.WriteLock(ctx, id); err != nil {
+		err = fmt.Errorf("failed to write lock bucket: %w", err)
+		return
+	}
+	defer s.locker.WriteUnlock(id)
+
+	if s.existsBucket(id) {
+		return ErrBucketAlreadyExists
+	}
+
+	path, err := s.findTrashed(id)
+	if err != nil {
+		return
+	}
+
+	metaPath := filepath.Join(path, s.getMetaFile(id))
+	bucketMeta, err := readMetaFile(metaPath)
+	if err != nil {
+		return
+	}
+	if bucketMeta.Stats == nil {
+		stats, statsErr := statTree(path, func(rel string) bool { return rel == s.getMetaFile(id) })
+		if statsErr != nil {
+			return fmt.Errorf("failed to compute bucket stats: %w", statsErr)
+		}
+		bucketMeta.Stats = &stats
+	}
+	bucketMeta.TrashTime = nil
+	if ttl != nil {
+		trashTime := time.Now().Add(*ttl)
+		bucketMeta.TrashTime = &trashTime
+	}
+	if err = s.writeMeta(metaPath, bucketMeta); err != nil {
+		return
+	}
+
+	if err = s.charge(bucketMeta.Stats.Size, nil); err != nil {
+		return
+	}
+	if err = os.Rename(path, s.getAbsPath(id)); err != nil {
+		s.release(bucketMeta.Stats.Size)
+		return fmt.Errorf("failed to move bucket from trash: %w", err)
+	}
+
+	return
+}
+
+// PurgeTrash Окончательно удаляет бакеты, перемещённые в корзину раньше before
+// Каждый бакет блокируется в режиме на запись на время удаления, чтобы не пересечься с RestoreBucket
+// Посторонние записи в корзине не удаляются
+func (s *Storage) PurgeTrash(
+	ctx context.Context,
+	before time.Time,
+) error {
+	entries, err := os.ReadDir(s.trashDir)
+	if err != nil {
+		return fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		id, trashedAt, ok := parseTrashName(entry.Name())
+		if !ok {
+			s.log.Warn(fmt.Sprintf("purge: unexpected entry %s in trash", filepath.Join(s.trashDir, entry.Name())))
+			continue
+		}
+		if !trashedAt.Before(before) {
+			continue
+		}
+		if err := s.purgeTrashed(ctx, id, entry.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeTrashed removes the trash entry name holding a copy of bucket id.
+// A copy restored in the meantime is already gone, which RemoveAll tolerates.
+func (s *Storage) purgeTrashed(ctx context.Context, id bucket.ID, name string) error {
+	if err := s.locker.WriteLock(ctx, id); err != nil {
+		return fmt.Errorf("failed to write lock bucket: %w", err)
+	}
+	defer s.locker.WriteUnlock(id)
+
+	if err := os.RemoveAll(filepath.Join(s.trashDir, name)); err != nil {
+		return fmt.Errorf("failed to remove trashed bucket %s: %w", name, err)
+	}
+	return nil
+}
+
+// findTrashed returns the path of the latest trashed copy of bucket id.
+func (s *Storage) findTrashed(id bucket.ID) (string, error) {
+	entries, err := os.ReadDir(s.trashDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	var (
+		latest   string
+		latestAt time.Time
+		foundAny bool
+	)
+	for _, entry := range entries {
+		trashedID, trashedAt, ok := parseTrashName(entry.Name())
+		if !ok || trashedID != id || !entry.IsDir() {
+			continue
+		}
+		if !foundAny || trashedAt.After(latestAt) {
+			latest, latestAt, foundAny = entry.Name(), trashedAt, true
+		}
+	}
+	if !foundAny {
+		return "", ErrBucketNotFound
+	}
+	return filepath.Join(s.trashDir, latest), nil
+}
+
+// parseTrashName parses the name of a trash entry, <id>.<unix nano time it was trashed at>.
+func parseTrashName(name string) (id bucket.ID, trashedAt time.Time, ok bool) {
+	idStr, nanosStr, found := strings.Cut(name, ".")
+	if !found {
+		return id, trashedAt, false
+	}
+	if err := id.FromString(idStr); err != nil {
+		return id, trashedAt, false
+	}
+	nanos, err := strconv.ParseInt(nanosStr, 10, 64)
+	if err != nil {
+		return id, trashedAt, false
+	}
+	return id, time.Unix(0, nanos), true
+}
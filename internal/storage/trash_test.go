@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/DIvanCode/filestorage/pkg/config"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func withGracePeriod(gracePeriod int) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Trasher.GracePeriod = gracePeriod
+	}
+}
+
+// expireBucket sets the ttl of bucket id to have run out.
+func expireBucket(t *testing.T, s *testStorage, id bucket.ID) {
+	ttl := -time.Second
+	require.NoError(t, s.extendTTL(context.Background(), id, &ttl))
+}
+
+func Test_TrashBucket_Restore(t *testing.T) {
+	s := newTestStorage(t, withGracePeriod(3600))
+
+	id := newBucketID(t, 1)
+	commitBucket(t, s, id, map[string]string{"a.txt": "abc"})
+	require.Equal(t, int64(3), s.Usage())
+
+	expireBucket(t, s, id)
+	require.NoError(t, s.TrashBucket(context.Background(), id))
+	require.Equal(t, int64(0), s.Usage())
+
+	_, _, err := s.GetBucket(context.Background(), id, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+	ids, err := s.ListBuckets(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	ttl := time.Hour
+	require.NoError(t, s.RestoreBucket(context.Background(), id, &ttl))
+	require.Equal(t, int64(3), s.Usage())
+
+	path, unlock, err := s.GetBucket(context.Background(), id, nil)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(path, "a.txt"))
+	unlock()
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(data))
+
+	trashTime, err := s.GetBucketTrashTime(context.Background(), id)
+	require.NoError(t, err)
+	require.True(t, trashTime.After(time.Now().Add(59*time.Minute)))
+
+	require.ErrorIs(t, s.RestoreBucket(context.Background(), id, nil), ErrBucketAlreadyExists)
+	require.ErrorIs(t, s.RestoreBucket(context.Background(), newBucketID(t, 2), nil), ErrBucketNotFound)
+}
+
+func Test_TrashBucket_WithoutGracePeriod(t *testing.T) {
+	s := newTestStorage(t, withGracePeriod(0))
+
+	id := newBucketID(t, 1)
+	commitBucket(t, s, id, map[string]string{"a.txt": "a"})
+
+	expireBucket(t, s, id)
+	require.NoError(t, s.TrashBucket(context.Background(), id))
+	_, _, err := s.GetBucket(context.Background(), id, nil)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+
+	entries, err := os.ReadDir(s.trashDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.ErrorIs(t, s.RestoreBucket(context.Background(), id, nil), ErrBucketNotFound)
+}
+
+func Test_PurgeTrash(t *testing.T) {
+	s := newTestStorage(t, withGracePeriod(3600))
+
+	oldID := newBucketID(t, 1)
+	commitBucket(t, s, oldID, map[string]string{"a.txt": "a"})
+	expireBucket(t, s, oldID)
+	require.NoError(t, s.TrashBucket(context.Background(), oldID))
+
+	cutoff := time.Now()
+
+	newID := newBucketID(t, 2)
+	commitBucket(t, s, newID, map[string]string{"a.txt": "a"})
+	expireBucket(t, s, newID)
+	require.NoError(t, s.TrashBucket(context.Background(), newID))
+
+	unexpected := filepath.Join(s.trashDir, "unexpected")
+	require.NoError(t, os.MkdirAll(unexpected, 0755))
+
+	// a bucket being restored is not purged under it
+	require.NoError(t, s.locker.WriteLock(context.Background(), oldID))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.PurgeTrash(ctx, cutoff), context.DeadlineExceeded)
+	s.locker.WriteUnlock(oldID)
+	_, err := s.findTrashed(oldID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.PurgeTrash(context.Background(), cutoff))
+
+	require.ErrorIs(t, s.RestoreBucket(context.Background(), oldID, nil), ErrBucketNotFound)
+	require.NoError(t, s.RestoreBucket(context.Background(), newID, nil))
+	require.DirExists(t, unexpected)
+}
+
+func Test_TrashBucket_KeepsPinnedAndExtended(t *testing.T) {
+	for _, gracePeriod := range []int{0, 3600} {
+		s := newTestStorage(t, withGracePeriod(gracePeriod))
+
+		pinnedID := newBucketID(t, 1)
+		commitBucket(t, s, pinnedID, map[string]string{"a.txt": "a"})
+		expireBucket(t, s, pinnedID)
+		pin, err := s.PinBucket(context.Background(), pinnedID, "", nil)
+		require.NoError(t, err)
+
+		extendedID := newBucketID(t, 2)
+		commitBucket(t, s, extendedID, map[string]string{"a.txt": "a"})
+		ttl := time.Hour
+		require.NoError(t, s.extendTTL(context.Background(), extendedID, &ttl))
+
+		permanentID := newBucketID(t, 3)
+		commitBucket(t, s, permanentID, map[string]string{"a.txt": "a"})
+
+		for _, id := range []bucket.ID{pinnedID, extendedID, permanentID} {
+			require.NoError(t, s.TrashBucket(context.Background(), id))
+			_, unlock, err := s.GetBucket(context.Background(), id, nil)
+			require.NoError(t, err, id.String())
+			unlock()
+		}
+		require.Equal(t, int64(3), s.Usage())
+
+		require.NoError(t, s.UnpinBucket(context.Background(), pinnedID, pin.ID))
+		require.NoError(t, s.TrashBucket(context.Background(), pinnedID))
+		_, _, err = s.GetBucket(context.Background(), pinnedID, nil)
+		require.ErrorIs(t, err, ErrBucketNotFound)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/DIvanCode/filestorage/internal/lib/queue"
@@ -19,6 +21,9 @@ type Trasher struct {
 
 	collectedBucketsQueue *queue.Queue[bucket.ID]
 
+	accessMu   sync.Mutex
+	lastAccess map[bucket.ID]time.Time
+
 	cancelFunc context.CancelFunc
 
 	log *slog.Logger
@@ -26,15 +31,32 @@ type Trasher struct {
 
 type FileStorage interface {
 	GetBucketMeta(ctx context.Context, id bucket.ID) (BucketMeta, error)
-	RemoveBucket(ctx context.Context, id bucket.ID) error
+	// TrashBucket removes bucket id if it is still expired and not pinned,
+	// keeping it restorable for the grace period if there is one.
+	TrashBucket(ctx context.Context, id bucket.ID) error
+	// PurgeTrash removes the buckets trashed before the given time for good.
+	PurgeTrash(ctx context.Context, before time.Time) error
+	// Usage returns the total size of stored files in bytes.
+	Usage() int64
+	// EvictBucket removes bucket id unless it is locked; evicted is false if it was left in place.
+	EvictBucket(ctx context.Context, id bucket.ID) (evicted bool, err error)
 }
 
 func NewTrasher(log *slog.Logger, cfg config.TrasherConfig) (*Trasher, error) {
+	if cfg.GracePeriod < 0 {
+		return nil, fmt.Errorf("invalid grace period %d", cfg.GracePeriod)
+	}
+	if cfg.HighWatermark < 0 || cfg.LowWatermark < 0 || cfg.LowWatermark > cfg.HighWatermark {
+		return nil, fmt.Errorf("invalid eviction watermarks: low %d, high %d", cfg.LowWatermark, cfg.HighWatermark)
+	}
+
 	trasher := &Trasher{
 		cfg: cfg,
 
 		collectedBucketsQueue: queue.NewQueue[bucket.ID](),
 
+		lastAccess: make(map[bucket.ID]time.Time),
+
 		log: log,
 	}
 
@@ -55,6 +77,17 @@ func (t *Trasher) Stop() {
 	t.cancelFunc()
 }
 
+// Touch records an access to bucket id for eviction; it does nothing if eviction is disabled.
+func (t *Trasher) Touch(id bucket.ID) {
+	if t.cfg.HighWatermark <= 0 {
+		return
+	}
+
+	t.accessMu.Lock()
+	t.lastAccess[id] = time.Now()
+	t.accessMu.Unlock()
+}
+
 func (t *Trasher) startCollector(ctx context.Context, storage FileStorage, rootDir string) {
 	go func() {
 		for {
@@ -70,6 +103,17 @@ func (t *Trasher) startCollector(ctx context.Context, storage FileStorage, rootD
 			if err := t.collect(ctx, storage, rootDir); err != nil {
 				t.log.Error(fmt.Sprintf("error collecting: %v", err))
 			}
+
+			gracePeriod := time.Duration(t.cfg.GracePeriod) * time.Second
+			if err := storage.PurgeTrash(ctx, time.Now().Add(-gracePeriod)); err != nil {
+				t.log.Error(fmt.Sprintf("error purging trash: %v", err))
+			}
+
+			if t.cfg.HighWatermark > 0 {
+				if err := t.evict(ctx, storage, rootDir); err != nil {
+					t.log.Error(fmt.Sprintf("error evicting: %v", err))
+				}
+			}
 		}
 	}()
 }
@@ -133,7 +177,7 @@ func (t *Trasher) collectBucket(storage FileStorage, bucketDir string) error {
 		return fmt.Errorf("error getting bucket %s: %v", bucketID, err)
 	}
 
-	if meta.TrashTime == nil || meta.TrashTime.After(time.Now()) {
+	if !trashable(meta, time.Now()) {
 		return nil
 	}
 
@@ -142,6 +186,14 @@ func (t *Trasher) collectBucket(storage FileStorage, bucketDir string) error {
 	return nil
 }
 
+// trashable reports whether the bucket has outlived its ttl and is not pinned.
+func trashable(meta BucketMeta, now time.Time) bool {
+	if meta.TrashTime == nil || meta.TrashTime.After(now) {
+		return false
+	}
+	return !meta.Pinned(now)
+}
+
 func (t *Trasher) startWorker(ctx context.Context, storage FileStorage) {
 	go func() {
 		for {
@@ -162,7 +214,15 @@ func (t *Trasher) startWorker(ctx context.Context, storage FileStorage) {
 			remove := func(bucketID bucket.ID) error {
 				removeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
 				defer cancel()
-				if err := storage.RemoveBucket(removeCtx, bucketID); err != nil {
+				// the bucket may have been pinned or extended since it was collected
+				meta, err := storage.GetBucketMeta(removeCtx, bucketID)
+				if err != nil {
+					return err
+				}
+				if !trashable(meta, time.Now()) {
+					return nil
+				}
+				if err := storage.TrashBucket(removeCtx, bucketID); err != nil {
 					return err
 				}
 				return nil
@@ -174,3 +234,111 @@ func (t *Trasher) startWorker(ctx context.Context, storage FileStorage) {
 		}
 	}()
 }
+
+type evictionCandidate struct {
+	id         bucket.ID
+	lastAccess time.Time
+}
+
+// evict removes the least recently accessed buckets while the storage is above the high watermark
+// until it drops to the low watermark. Locked buckets, including the ones being read or reserved, are skipped.
+func (t *Trasher) evict(ctx context.Context, storage FileStorage, rootDir string) error {
+	if storage.Usage() <= t.cfg.HighWatermark {
+		return nil
+	}
+
+	candidates, err := t.evictionCandidates(ctx, rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if storage.Usage() <= t.cfg.LowWatermark {
+			return nil
+		}
+
+		evictCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		evicted, err := storage.EvictBucket(evictCtx, candidate.id)
+		cancel()
+		if err != nil {
+			t.log.Error(fmt.Sprintf("error evicting bucket %s: %v", candidate.id, err))
+			continue
+		}
+		if !evicted {
+			continue
+		}
+
+		t.accessMu.Lock()
+		delete(t.lastAccess, candidate.id)
+		t.accessMu.Unlock()
+		t.log.Info(fmt.Sprintf("evicted bucket %s last accessed at %s", candidate.id, candidate.lastAccess.Format(time.RFC3339)))
+	}
+
+	if usage := storage.Usage(); usage > t.cfg.LowWatermark {
+		t.log.Warn(fmt.Sprintf("storage still uses %d bytes after eviction, low watermark is %d", usage, t.cfg.LowWatermark))
+	}
+	return nil
+}
+
+// evictionCandidates lists the buckets under rootDir, least recently accessed first.
+// Buckets not accessed since start are dated by the modification time of their directory.
+func (t *Trasher) evictionCandidates(ctx context.Context, rootDir string) ([]evictionCandidate, error) {
+	shards, err := os.ReadDir(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	t.accessMu.Lock()
+	defer t.accessMu.Unlock()
+
+	seen := make(map[bucket.ID]bool)
+	var candidates []evictionCandidate
+	for _, shard := range shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		shardDir := filepath.Join(rootDir, shard.Name())
+		buckets, err := os.ReadDir(shardDir)
+		if err != nil {
+			t.log.Error(fmt.Sprintf("error reading shard %s: %v", shardDir, err))
+			continue
+		}
+
+		for _, bucketDir := range buckets {
+			var id bucket.ID
+			if err := id.FromString(bucketDir.Name()); err != nil {
+				continue
+			}
+			seen[id] = true
+
+			lastAccess, ok := t.lastAccess[id]
+			if !ok {
+				info, err := bucketDir.Info()
+				if err != nil {
+					continue
+				}
+				lastAccess = info.ModTime()
+			}
+			candidates = append(candidates, evictionCandidate{id: id, lastAccess: lastAccess})
+		}
+	}
+
+	// forget buckets removed by other means
+	for id := range t.lastAccess {
+		if !seen[id] {
+			delete(t.lastAccess, id)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].lastAccess.Equal(candidates[j].lastAccess) {
+			return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+		}
+		return candidates[i].id.String() < candidates[j].id.String()
+	})
+	return candidates, nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,11 +40,75 @@ func (m *MockStorage) GetBucketMeta(ctx context.Context, id bucket.ID) (BucketMe
 	return args.Get(0).(BucketMeta), args.Error(1)
 }
 
-func (m *MockStorage) RemoveBucket(ctx context.Context, id bucket.ID) error {
+func (m *MockStorage) TrashBucket(ctx context.Context, id bucket.ID) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+func (m *MockStorage) PurgeTrash(ctx context.Context, before time.Time) error {
+	args := m.Called(before)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Usage() int64 {
+	args := m.Called()
+	return args.Get(0).(int64)
+}
+
+func (m *MockStorage) EvictBucket(ctx context.Context, id bucket.ID) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+// evictionStorage keeps bucket sizes in memory and accounts evictions like the storage does.
+type evictionStorage struct {
+	mu      sync.Mutex
+	sizes   map[bucket.ID]int64
+	locked  map[bucket.ID]bool
+	evicted []bucket.ID
+}
+
+func (s *evictionStorage) GetBucketMeta(ctx context.Context, id bucket.ID) (BucketMeta, error) {
+	return BucketMeta{BucketID: id}, nil
+}
+
+func (s *evictionStorage) TrashBucket(ctx context.Context, id bucket.ID) error {
+	return nil
+}
+
+func (s *evictionStorage) PurgeTrash(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+func (s *evictionStorage) Usage() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var usage int64
+	for _, size := range s.sizes {
+		usage += size
+	}
+	return usage
+}
+
+func (s *evictionStorage) EvictBucket(ctx context.Context, id bucket.ID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked[id] {
+		return false, nil
+	}
+	delete(s.sizes, id)
+	s.evicted = append(s.evicted, id)
+	return true, nil
+}
+
+func (s *evictionStorage) Evicted() []bucket.ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bucket.ID(nil), s.evicted...)
+}
+
 func TestTrasherCollectAndRemove(t *testing.T) {
 	tmpRoot := t.TempDir()
 	shardDir := filepath.Join(tmpRoot, "00")
@@ -57,7 +122,8 @@ func TestTrasherCollectAndRemove(t *testing.T) {
 
 	mockStorage := new(MockStorage)
 	mockStorage.On("GetBucketMeta", bucketID).Return(meta, nil)
-	mockStorage.On("RemoveBucket", bucketID).Return(nil)
+	mockStorage.On("TrashBucket", bucketID).Return(nil)
+	mockStorage.On("PurgeTrash", mock.Anything).Return(nil)
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
@@ -76,5 +142,119 @@ func TestTrasherCollectAndRemove(t *testing.T) {
 	trasher.Stop()
 
 	mockStorage.AssertCalled(t, "GetBucketMeta", bucketID)
-	mockStorage.AssertCalled(t, "RemoveBucket", bucketID)
+	mockStorage.AssertCalled(t, "TrashBucket", bucketID)
+}
+
+func TestTrasherSkipsPinnedBucket(t *testing.T) {
+	tmpRoot := t.TempDir()
+	shardDir := filepath.Join(tmpRoot, "00")
+	require.NoError(t, os.MkdirAll(shardDir, 0777))
+
+	bucketID := newBucketID(t, 1)
+	require.NoError(t, os.Mkdir(filepath.Join(shardDir, bucketID.String()), 0777))
+
+	trashTime := time.Now().Add(-time.Hour)
+	meta := BucketMeta{BucketID: bucketID, TrashTime: &trashTime, Pins: []bucket.Pin{{ID: "pin"}}}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetBucketMeta", bucketID).Return(meta, nil)
+	mockStorage.On("PurgeTrash", mock.Anything).Return(nil)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	trasher, err := trash.NewTrasher(
+		logger,
+		config.TrasherConfig{
+			CollectorIterationsDelay: 1,
+			WorkerIterationsDelay:    1,
+			Workers:                  1,
+		},
+	)
+	require.NoError(t, err)
+
+	trasher.Start(mockStorage, tmpRoot)
+	time.Sleep(3 * time.Second)
+	trasher.Stop()
+
+	mockStorage.AssertCalled(t, "GetBucketMeta", bucketID)
+	mockStorage.AssertNotCalled(t, "TrashBucket", bucketID)
+}
+
+func TestTrasherEvictsLeastRecentlyAccessed(t *testing.T) {
+	tmpRoot := t.TempDir()
+	shardDir := filepath.Join(tmpRoot, "00")
+	require.NoError(t, os.MkdirAll(shardDir, 0777))
+
+	storage := &evictionStorage{
+		sizes:  make(map[bucket.ID]int64),
+		locked: make(map[bucket.ID]bool),
+	}
+	ids := []bucket.ID{newBucketID(t, 1), newBucketID(t, 2), newBucketID(t, 3)}
+	for i, id := range ids {
+		dir := filepath.Join(shardDir, id.String())
+		require.NoError(t, os.Mkdir(dir, 0777))
+		// the first bucket was created first
+		modTime := time.Now().Add(time.Duration(i-len(ids)) * time.Hour)
+		require.NoError(t, os.Chtimes(dir, modTime, modTime))
+		storage.sizes[id] = 40
+	}
+	// the second bucket is in use
+	storage.locked[ids[1]] = true
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	trasher, err := trash.NewTrasher(
+		logger,
+		config.TrasherConfig{
+			CollectorIterationsDelay: 1,
+			WorkerIterationsDelay:    1,
+			HighWatermark:            100,
+			LowWatermark:             80,
+		},
+	)
+	require.NoError(t, err)
+
+	// the first bucket is the oldest but was read recently
+	trasher.Touch(ids[0])
+
+	trasher.Start(storage, tmpRoot)
+	require.Eventually(t, func() bool {
+		return len(storage.Evicted()) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+	trasher.Stop()
+
+	require.Equal(t, []bucket.ID{ids[2]}, storage.Evicted())
+	require.Equal(t, int64(80), storage.Usage())
+}
+
+func TestNewTrasherRejectsInvalidWatermarks(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	_, err := trash.NewTrasher(logger, config.TrasherConfig{HighWatermark: 10, LowWatermark: 20})
+	require.Error(t, err)
+}
+
+func TestTrasherPurgesTrashAfterGracePeriod(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("PurgeTrash", mock.Anything).Return(nil)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	trasher, err := trash.NewTrasher(
+		logger,
+		config.TrasherConfig{
+			CollectorIterationsDelay: 1,
+			WorkerIterationsDelay:    1,
+			GracePeriod:              3600,
+		},
+	)
+	require.NoError(t, err)
+
+	trasher.Start(mockStorage, t.TempDir())
+	time.Sleep(2 * time.Second)
+	trasher.Stop()
+
+	mockStorage.AssertCalled(t, "PurgeTrash", mock.MatchedBy(func(before time.Time) bool {
+		return before.Before(time.Now().Add(-59*time.Minute)) && before.After(time.Now().Add(-61*time.Minute))
+	}))
 }
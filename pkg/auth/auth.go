@@ -0,0 +1,126 @@
+// Package auth authenticates requests to the HTTP API and decides what they may do.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// Permission is a set of operations on buckets.
+type Permission uint8
+
+const (
+	// PermissionRead allows downloading, describing and listing buckets and files.
+	PermissionRead Permission = 1 << iota
+	// PermissionWrite allows uploading buckets and files and pinning buckets.
+	PermissionWrite
+	// PermissionDelete allows removing buckets and files.
+	PermissionDelete
+)
+
+var permissionNames = []struct {
+	permission Permission
+	name       string
+}{
+	{permission: PermissionRead, name: "read"},
+	{permission: PermissionWrite, name: "write"},
+	{permission: PermissionDelete, name: "delete"},
+}
+
+// ParsePermissions returns the set of the named permissions: read, write and delete.
+func ParsePermissions(names []string) (Permission, error) {
+	var p Permission
+	for _, name := range names {
+		found := false
+		for _, pn := range permissionNames {
+			if pn.name == name {
+				p |= pn.permission
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown permission %q", name)
+		}
+	}
+	return p, nil
+}
+
+// String returns the comma separated names of the permissions in p, as accepted by ParsePermissions.
+func (p Permission) String() string {
+	var names []string
+	for _, pn := range permissionNames {
+		if p&pn.permission != 0 {
+			names = append(names, pn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Grant is what an authenticated request may do.
+type Grant struct {
+	Permissions Permission
+	// Prefixes limit the grant to the buckets whose IDs start with one of them; empty allows every bucket
+	Prefixes []string
+}
+
+// Allows reports whether the grant includes p on bucket id.
+func (g Grant) Allows(p Permission, id bucket.ID) bool {
+	return g.AllowsPrefix(p, id.String())
+}
+
+// AllowsPrefix reports whether the grant includes p on every bucket whose ID starts with prefix.
+func (g Grant) AllowsPrefix(p Permission, prefix string) bool {
+	if g.Permissions&p != p {
+		return false
+	}
+	if len(g.Prefixes) == 0 {
+		return true
+	}
+	for _, allowed := range g.Prefixes {
+		if strings.HasPrefix(prefix, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g Grant) validate() error {
+	for _, prefix := range g.Prefixes {
+		if prefix == "" || len(prefix) > len(bucket.ID{}) || strings.Trim(prefix, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid bucket prefix %q", prefix)
+		}
+	}
+	return nil
+}
+
+// Authenticator finds the credentials of a request and returns what they grant.
+// It returns an error wrapping ErrNoCredentials if the request carries none of its kind
+// and one wrapping pkg/errors.ErrUnauthorized if they are not valid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Grant, error)
+}
+
+var ErrNoCredentials = fmt.Errorf("%w: no credentials", fserrors.ErrUnauthorized)
+
+type chain []Authenticator
+
+// Chain authenticates a request with the first of authenticators that finds its credentials in it.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+func (c chain) Authenticate(r *http.Request) (Grant, error) {
+	for _, a := range c {
+		grant, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return grant, err
+	}
+	return Grant{}, ErrNoCredentials
+}
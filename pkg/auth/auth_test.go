@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newBucketID(t *testing.T, s string) bucket.ID {
+	var id bucket.ID
+	require.NoError(t, id.FromString(s))
+	return id
+}
+
+func TestParsePermissions(t *testing.T) {
+	p, err := ParsePermissions([]string{"read", "delete"})
+	require.NoError(t, err)
+	require.Equal(t, PermissionRead|PermissionDelete, p)
+	require.Equal(t, "read,delete", p.String())
+
+	_, err = ParsePermissions([]string{"admin"})
+	require.Error(t, err)
+}
+
+func TestGrantAllows(t *testing.T) {
+	id := newBucketID(t, "ab00000000000000000000000000000000000001")
+	other := newBucketID(t, "cd00000000000000000000000000000000000001")
+
+	all := Grant{Permissions: PermissionRead | PermissionWrite}
+	require.True(t, all.Allows(PermissionRead, id))
+	require.True(t, all.Allows(PermissionRead|PermissionWrite, other))
+	require.False(t, all.Allows(PermissionDelete, id))
+
+	limited := Grant{Permissions: PermissionRead, Prefixes: []string{"ab", "ef0"}}
+	require.True(t, limited.Allows(PermissionRead, id))
+	require.False(t, limited.Allows(PermissionRead, other))
+	require.True(t, limited.AllowsPrefix(PermissionRead, "ab1"))
+	require.False(t, limited.AllowsPrefix(PermissionRead, "ef"))
+	require.False(t, limited.AllowsPrefix(PermissionRead, ""))
+}
+
+func TestTokens(t *testing.T) {
+	grant := Grant{Permissions: PermissionRead, Prefixes: []string{"ab"}}
+	tokens, err := NewTokens(map[string]Grant{"secret": grant})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/bucket", nil)
+	_, err = tokens.Authenticate(req)
+	require.ErrorIs(t, err, ErrNoCredentials)
+	require.ErrorIs(t, err, fserrors.ErrUnauthorized)
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	_, err = tokens.Authenticate(req)
+	require.ErrorIs(t, err, fserrors.ErrUnauthorized)
+	require.NotErrorIs(t, err, ErrNoCredentials)
+
+	req.Header.Set("Authorization", "bearer secret")
+	got, err := tokens.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, grant, got)
+}
+
+func TestNewTokensRejectsInvalidConfig(t *testing.T) {
+	_, err := NewTokens(map[string]Grant{"": {Permissions: PermissionRead}})
+	require.Error(t, err)
+
+	_, err = NewTokens(map[string]Grant{"secret": {Permissions: PermissionRead, Prefixes: []string{"AB"}}})
+	require.Error(t, err)
+}
+
+func TestSigner(t *testing.T) {
+	signer, err := NewSigner([]byte("key"))
+	require.NoError(t, err)
+	u, err := url.Parse("http://storage:8080/v1/buckets/ab00000000000000000000000000000000000001/files/a%20b.txt?raw=true")
+	require.NoError(t, err)
+
+	signed := signer.Sign(http.MethodGet, u, PermissionRead, time.Now().Add(time.Minute))
+	require.Equal(t, "read", signed.Query().Get(ParamPermissions))
+
+	grant, err := signer.Authenticate(httptest.NewRequest(http.MethodGet, signed.String(), nil))
+	require.NoError(t, err)
+	require.Equal(t, Grant{Permissions: PermissionRead}, grant)
+
+	_, err = signer.Authenticate(httptest.NewRequest(http.MethodGet, u.String(), nil))
+	require.ErrorIs(t, err, ErrNoCredentials)
+
+	tamper := func(change func(u *url.URL, query url.Values)) *http.Request {
+		tampered := *signed
+		query := tampered.Query()
+		change(&tampered, query)
+		tampered.RawQuery = query.Encode()
+		return httptest.NewRequest(http.MethodGet, tampered.String(), nil)
+	}
+	requests := map[string]*http.Request{
+		"path": tamper(func(u *url.URL, _ url.Values) {
+			u.Path, u.RawPath = "/v1/buckets/ab00000000000000000000000000000000000002/files/a b.txt", ""
+		}),
+		"query":       tamper(func(_ *url.URL, query url.Values) { query.Set("raw", "false") }),
+		"permissions": tamper(func(_ *url.URL, query url.Values) { query.Set(ParamPermissions, "read,delete") }),
+		"expiry":      tamper(func(_ *url.URL, query url.Values) { query.Set(ParamExpires, "9999999999") }),
+	}
+	requests["method"] = httptest.NewRequest(http.MethodDelete, signed.String(), nil)
+	for name, req := range requests {
+		_, err := signer.Authenticate(req)
+		require.ErrorIs(t, err, fserrors.ErrUnauthorized, name)
+	}
+
+	// resuming a download keeps the signature valid
+	resumed := tamper(func(_ *url.URL, query url.Values) {
+		query.Set("resume-entry", "a b.txt")
+		query.Set("resume-offset", "10")
+	})
+	_, err = signer.Authenticate(resumed)
+	require.NoError(t, err)
+
+	other, err := NewSigner([]byte("other key"))
+	require.NoError(t, err)
+	_, err = other.Authenticate(httptest.NewRequest(http.MethodGet, signed.String(), nil))
+	require.ErrorIs(t, err, fserrors.ErrUnauthorized)
+
+	expired := signer.Sign(http.MethodGet, u, PermissionRead, time.Now().Add(-time.Second))
+	_, err = signer.Authenticate(httptest.NewRequest(http.MethodGet, expired.String(), nil))
+	require.ErrorIs(t, err, fserrors.ErrUnauthorized)
+}
+
+func TestChain(t *testing.T) {
+	tokens, err := NewTokens(map[string]Grant{"secret": {Permissions: PermissionWrite}})
+	require.NoError(t, err)
+	signer, err := NewSigner([]byte("key"))
+	require.NoError(t, err)
+	chain := Chain(tokens, signer)
+
+	req := httptest.NewRequest(http.MethodGet, "/bucket", nil)
+	_, err = chain.Authenticate(req)
+	require.ErrorIs(t, err, ErrNoCredentials)
+
+	req.Header.Set("Authorization", "Bearer secret")
+	grant, err := chain.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, PermissionWrite, grant.Permissions)
+
+	u := signer.Sign(http.MethodGet, &url.URL{Path: "/bucket"}, PermissionRead, time.Now().Add(time.Minute))
+	grant, err = chain.Authenticate(httptest.NewRequest(http.MethodGet, u.String(), nil))
+	require.NoError(t, err)
+	require.Equal(t, PermissionRead, grant.Permissions)
+}
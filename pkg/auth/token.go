@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	fserrors "github.com/DIvanCode/filestorage/pkg/errors"
+)
+
+// Tokens authenticates requests by a static bearer token in the Authorization header.
+type Tokens struct {
+	// grants are keyed by the SHA-256 of the token, so that looking a token up
+	// takes the same time however much of it matches a valid one
+	grants map[[sha256.Size]byte]Grant
+}
+
+// NewTokens returns an authenticator accepting the given tokens with their grants.
+func NewTokens(tokens map[string]Grant) (*Tokens, error) {
+	t := &Tokens{grants: make(map[[sha256.Size]byte]Grant, len(tokens))}
+	for token, grant := range tokens {
+		if token == "" {
+			return nil, errors.New("empty token")
+		}
+		if err := grant.validate(); err != nil {
+			return nil, err
+		}
+		t.grants[sha256.Sum256([]byte(token))] = grant
+	}
+	return t, nil
+}
+
+func (t *Tokens) Authenticate(r *http.Request) (Grant, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Grant{}, ErrNoCredentials
+	}
+
+	grant, ok := t.grants[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !ok {
+		return Grant{}, fmt.Errorf("%w: invalid token", fserrors.ErrUnauthorized)
+	}
+	return grant, nil
+}
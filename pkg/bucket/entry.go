@@ -0,0 +1,25 @@
+package bucket
+
+import (
+	"io/fs"
+	"time"
+)
+
+type EntryType string
+
+const (
+	EntryFile EntryType = "file"
+	EntryDir  EntryType = "dir"
+)
+
+// Entry describes a file or directory in a bucket.
+type Entry struct {
+	// Path is slash-separated and relative to the bucket root
+	Path    string      `json:"path"`
+	Type    EntryType   `json:"type"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	// Digest is the hex encoded SHA-256 recorded for the file, empty for directories and unrecorded files
+	Digest string `json:"digest,omitempty"`
+}
@@ -0,0 +1,13 @@
+package bucket
+
+// Filter selects buckets in listings. Zero fields match every bucket.
+type Filter struct {
+	// Prefix is a prefix of the hex ID
+	Prefix string
+	// Expired, if set, matches the buckets whose ttl has passed (true) or has not (false).
+	// Buckets without ttl never expire
+	Expired *bool
+	// PinOwner matches the buckets holding an active pin whose owner is PinOwner.
+	// A bucket stops matching once its pin is removed or expires
+	PinOwner string
+}
@@ -0,0 +1,18 @@
+package bucket
+
+import "time"
+
+// Pin protects a bucket from being trashed or evicted while it is held.
+// A bucket stays pinned while it has at least one pin that has not expired.
+type Pin struct {
+	ID string `json:"id"`
+	// Owner is an optional label of whoever holds the pin
+	Owner string `json:"owner,omitempty"`
+	// Expires is the end of the lease; a pin without it is held until removed
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Active reports whether the pin still holds at now.
+func (p Pin) Active(now time.Time) bool {
+	return p.Expires == nil || p.Expires.After(now)
+}
@@ -0,0 +1,14 @@
+package bucket
+
+import "time"
+
+// Stat describes a stored bucket. Size, FileCount and DirCount do not include its meta file.
+type Stat struct {
+	ID        ID         `json:"id"`
+	Size      int64      `json:"size"`
+	FileCount int        `json:"file_count"`
+	DirCount  int        `json:"dir_count"`
+	TrashTime *time.Time `json:"trash_time,omitempty"`
+	// Pins are the pins currently held on the bucket
+	Pins []Pin `json:"pins,omitempty"`
+}
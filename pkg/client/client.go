@@ -0,0 +1,268 @@
+// Package client talks to a remote filestorage node over its HTTP API.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/DIvanCode/filestorage/internal/api/client"
+	"github.com/DIvanCode/filestorage/internal/lib/tarstream"
+	"github.com/DIvanCode/filestorage/pkg/auth"
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+)
+
+// StatusError is returned when the node answers with an unexpected status code.
+// errors.Is matches it against ErrBucketNotFound, ErrFileNotFound, ErrInvalidPath and
+// the other pkg/errors sentinels according to the error code in the response.
+type StatusError = client.StatusError
+
+// Compression is the encoding archives are transferred in.
+type Compression = tarstream.Compression
+
+const (
+	CompressionNone = tarstream.CompressionNone
+	CompressionGzip = tarstream.CompressionGzip
+	CompressionZstd = tarstream.CompressionZstd
+)
+
+type Client struct {
+	client  *client.Client
+	timeout time.Duration
+}
+
+type options struct {
+	httpClient  *http.Client
+	timeout     time.Duration
+	retry       []client.Option
+	compression Compression
+	prefix      string
+	token       string
+	signingKey  []byte
+}
+
+type Option func(o *options)
+
+// WithHTTPClient sends requests through httpClient, e.g. to configure transport or TLS.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTimeout bounds every call, including the transfer of the archive itself.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetry retries interrupted downloads up to retries times with exponential backoff
+// starting at initialDelay and capped by maxDelay. A retried download continues where
+// the interrupted one stopped instead of starting over.
+func WithRetry(retries int, initialDelay, maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.retry = []client.Option{client.WithRetry(retries, initialDelay, maxDelay)}
+	}
+}
+
+// WithCompression transfers archives compressed with compression, e.g. to save bandwidth
+// on compressible data. Downloads fall back to uncompressed archives if the node does not
+// support it; uploads require a node that does.
+func WithCompression(compression Compression) Option {
+	return func(o *options) {
+		o.compression = compression
+	}
+}
+
+// WithPrefix talks to a node configured to serve its API under prefix, e.g. "/api".
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithToken authenticates every request with the bearer token, for nodes that require authentication.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithSigningKey authenticates every request by a URL signed with key, for nodes configured
+// with the same signing key and services trusted with it instead of a token.
+func WithSigningKey(key []byte) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// New creates a client for the node listening at baseURL, e.g. "http://storage:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q: scheme and host are required", baseURL)
+	}
+
+	o := options{httpClient: &http.Client{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	clientOpts := append(o.retry,
+		client.WithHTTPClient(o.httpClient),
+		client.WithCompression(o.compression),
+		client.WithPrefix(o.prefix),
+		client.WithToken(o.token))
+	if o.signingKey != nil {
+		signer, err := auth.NewSigner(o.signingKey)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, client.WithSigner(signer))
+	}
+	return &Client{
+		client:  client.NewClient(baseURL, clientOpts...),
+		timeout: o.timeout,
+	}, nil
+}
+
+// DownloadBucket downloads bucket id into the existing directory path.
+func (c *Client) DownloadBucket(ctx context.Context, id bucket.ID, path string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.DownloadBucket(ctx, id, path)
+}
+
+// DownloadFile downloads file of bucket bucketID into the existing directory path,
+// keeping its relative location.
+func (c *Client) DownloadFile(ctx context.Context, bucketID bucket.ID, file, path string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.DownloadFile(ctx, bucketID, file, path)
+}
+
+// ReadFile returns the contents of file of bucket bucketID as is rather than in an archive,
+// starting at offset. A positive length reads at most that many bytes. The caller must close the reader.
+func (c *Client) ReadFile(ctx context.Context, bucketID bucket.ID, file string, offset, length int64) (io.ReadCloser, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	body, err := c.client.OpenFile(ctx, bucketID, file, offset, length)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelReader{ReadCloser: body, cancel: cancel}, nil
+}
+
+// StatBucket returns the size, file and directory counts and trash time of bucket id.
+func (c *Client) StatBucket(ctx context.Context, id bucket.ID) (bucket.Stat, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.StatBucket(ctx, id)
+}
+
+// ListBucketsPage returns up to limit buckets matching filter with IDs greater than after in ID order.
+// Pass a zero after for the first page and the last ID of the previous page for the next one.
+func (c *Client) ListBucketsPage(ctx context.Context, after bucket.ID, limit int, filter bucket.Filter) ([]bucket.ID, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	ids := make([]bucket.ID, 0)
+	err := c.client.ListBuckets(ctx, after, limit, filter, func(id bucket.ID) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListFiles returns the files and directories of bucket id ordered by path.
+// prefix limits the listing to a directory of the bucket, recursive includes the contents of subdirectories.
+func (c *Client) ListFiles(ctx context.Context, id bucket.ID, prefix string, recursive bool) ([]bucket.Entry, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var entries []bucket.Entry
+	pageToken := ""
+	for {
+		resp, err := c.client.ListFiles(ctx, id, prefix, recursive, pageToken, 0)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, resp.Entries...)
+		if resp.NextPageToken == "" {
+			return entries, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// PinBucket pins bucket id so that it is neither trashed nor evicted until the pin is removed
+// or its lease expires. owner is an optional label, lease nil keeps the pin until UnpinBucket.
+func (c *Client) PinBucket(ctx context.Context, id bucket.ID, owner string, lease *time.Duration) (bucket.Pin, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.PinBucket(ctx, id, owner, lease)
+}
+
+// UnpinBucket removes pin pinID from bucket id.
+func (c *Client) UnpinBucket(ctx context.Context, id bucket.ID, pinID string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.UnpinBucket(ctx, id, pinID)
+}
+
+// RemoveBucket removes bucket id from the node. Removing a missing bucket is not an error.
+func (c *Client) RemoveBucket(ctx context.Context, id bucket.ID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.RemoveBucket(ctx, id)
+}
+
+// RemoveFile removes file or directory file from bucket bucketID.
+func (c *Client) RemoveFile(ctx context.Context, bucketID bucket.ID, file string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.RemoveFile(ctx, bucketID, file)
+}
+
+// UploadBucket uploads the contents of directory path as bucket id.
+// ttl is the lifetime of the bucket on the node (nil keeps it forever).
+func (c *Client) UploadBucket(ctx context.Context, id bucket.ID, path string, ttl *time.Duration) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.UploadBucket(ctx, id, path, ttl)
+}
+
+// UploadFile uploads file located relative to directory path into the existing bucket bucketID.
+func (c *Client) UploadFile(ctx context.Context, bucketID bucket.ID, file, path string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.UploadFile(ctx, bucketID, file, path)
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// cancelReader releases the context of the call that opened it once closed.
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
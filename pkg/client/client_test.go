@@ -0,0 +1,256 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DIvanCode/filestorage/pkg/bucket"
+	"github.com/DIvanCode/filestorage/pkg/client"
+	"github.com/DIvanCode/filestorage/pkg/config"
+	. "github.com/DIvanCode/filestorage/pkg/errors"
+	"github.com/DIvanCode/filestorage/pkg/filestorage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) (filestorage.FileStorage, *httptest.Server) {
+	return newTestServerWithAPI(t, config.APIConfig{})
+}
+
+func newTestServerWithAPI(t *testing.T, api config.APIConfig) (filestorage.FileStorage, *httptest.Server) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := config.Config{
+		RootDir: t.TempDir(),
+		Trasher: config.TrasherConfig{
+			Workers:                  1,
+			CollectorIterationsDelay: 60,
+			WorkerIterationsDelay:    60,
+		},
+		API: api,
+	}
+	mux := chi.NewRouter()
+
+	storage, err := filestorage.New(log, cfg, mux)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		storage.Shutdown()
+	})
+	return storage, srv
+}
+
+func newBucketID(t *testing.T, idStr string) bucket.ID {
+	var id bucket.ID
+	require.NoError(t, id.FromString(idStr))
+	return id
+}
+
+func TestNewRejectsInvalidBaseURL(t *testing.T) {
+	_, err := client.New("storage:8080")
+	require.Error(t, err)
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	_, srv := newTestServer(t)
+	c, err := client.New(srv.URL, client.WithHTTPClient(srv.Client()), client.WithTimeout(10*time.Second))
+	require.NoError(t, err)
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	ttl := time.Minute
+
+	source := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a", "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, c.UploadBucket(context.Background(), ID, source, &ttl))
+	require.ErrorIs(t, c.UploadBucket(context.Background(), ID, source, &ttl), ErrBucketAlreadyExists)
+
+	require.NoError(t, os.WriteFile(filepath.Join(source, "b.txt"), []byte("bbb"), 0644))
+	require.NoError(t, c.UploadFile(context.Background(), ID, "b.txt", source))
+	require.ErrorIs(t, c.UploadFile(context.Background(), ID, "b.txt", source), ErrFileAlreadyExists)
+
+	stat, err := c.StatBucket(context.Background(), ID)
+	require.NoError(t, err)
+	require.Equal(t, ID, stat.ID)
+	require.Equal(t, int64(6), stat.Size)
+	require.Equal(t, 2, stat.FileCount)
+	require.Equal(t, 1, stat.DirCount)
+	require.NotNil(t, stat.TrashTime)
+	require.Empty(t, stat.Pins)
+
+	pin, err := c.PinBucket(context.Background(), ID, "test", nil)
+	require.NoError(t, err)
+	require.Equal(t, "test", pin.Owner)
+	stat, err = c.StatBucket(context.Background(), ID)
+	require.NoError(t, err)
+	require.Equal(t, []bucket.Pin{pin}, stat.Pins)
+	require.NoError(t, c.UnpinBucket(context.Background(), ID, pin.ID))
+	require.ErrorIs(t, c.UnpinBucket(context.Background(), ID, pin.ID), ErrPinNotFound)
+
+	bucketDir := t.TempDir()
+	require.NoError(t, c.DownloadBucket(context.Background(), ID, bucketDir))
+	require.FileExists(t, filepath.Join(bucketDir, "a", "a.txt"))
+	require.FileExists(t, filepath.Join(bucketDir, "b.txt"))
+
+	fileDir := t.TempDir()
+	require.NoError(t, c.DownloadFile(context.Background(), ID, "b.txt", fileDir))
+	content, err := os.ReadFile(filepath.Join(fileDir, "b.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "bbb", string(content))
+
+	body, err := c.ReadFile(context.Background(), ID, "a/a.txt", 0, 0)
+	require.NoError(t, err)
+	content, err = io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, "aaa", string(content))
+
+	body, err = c.ReadFile(context.Background(), ID, "b.txt", 1, 1)
+	require.NoError(t, err)
+	content, err = io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, "b", string(content))
+	_, err = c.ReadFile(context.Background(), ID, "missing.txt", 0, 0)
+	require.ErrorIs(t, err, ErrFileNotFound)
+
+	nestedDir := t.TempDir()
+	require.NoError(t, c.DownloadFile(context.Background(), ID, "a/a.txt", nestedDir))
+	require.FileExists(t, filepath.Join(nestedDir, "a", "a.txt"))
+	require.ErrorIs(t, c.DownloadFile(context.Background(), ID, "a/missing.txt", t.TempDir()), ErrFileNotFound)
+
+	entries, err := c.ListFiles(context.Background(), ID, "", true)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "a", entries[0].Path)
+	require.Equal(t, bucket.EntryDir, entries[0].Type)
+	require.Equal(t, "b.txt", entries[2].Path)
+	require.Equal(t, int64(3), entries[2].Size)
+	require.NotEmpty(t, entries[2].Digest)
+
+	require.NoError(t, c.RemoveFile(context.Background(), ID, "b.txt"))
+	require.ErrorIs(t, c.RemoveFile(context.Background(), ID, "b.txt"), ErrFileNotFound)
+	stat, err = c.StatBucket(context.Background(), ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, stat.FileCount)
+
+	ids, err := c.ListBucketsPage(context.Background(), bucket.ID{}, 10, bucket.Filter{})
+	require.NoError(t, err)
+	require.Equal(t, []bucket.ID{ID}, ids)
+
+	require.NoError(t, c.RemoveBucket(context.Background(), ID))
+	_, err = c.StatBucket(context.Background(), ID)
+	require.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	for i, compression := range []client.Compression{client.CompressionGzip, client.CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			c, err := client.New(srv.URL, client.WithHTTPClient(srv.Client()), client.WithCompression(compression))
+			require.NoError(t, err)
+
+			ID := newBucketID(t, fmt.Sprintf("%040d", i+1))
+			source := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaa"), 0644))
+			require.NoError(t, c.UploadBucket(context.Background(), ID, source, nil))
+
+			destination := t.TempDir()
+			require.NoError(t, c.DownloadBucket(context.Background(), ID, destination))
+			content, err := os.ReadFile(filepath.Join(destination, "a.txt"))
+			require.NoError(t, err)
+			require.Equal(t, "aaa", string(content))
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	_, srv := newTestServerWithAPI(t, config.APIConfig{
+		Tokens: []config.TokenConfig{
+			{Token: "writer", Permissions: []string{"read", "write"}},
+			{Token: "reader", Permissions: []string{"read"}},
+		},
+		SigningKey: "key",
+	})
+	newClient := func(opts ...client.Option) *client.Client {
+		c, err := client.New(srv.URL, append(opts, client.WithHTTPClient(srv.Client()))...)
+		require.NoError(t, err)
+		return c
+	}
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaa"), 0644))
+
+	require.ErrorIs(t, newClient().UploadBucket(context.Background(), ID, source, nil), ErrUnauthorized)
+	require.ErrorIs(t, newClient(client.WithToken("reader")).UploadBucket(context.Background(), ID, source, nil), ErrForbidden)
+	require.NoError(t, newClient(client.WithToken("writer")).UploadBucket(context.Background(), ID, source, nil))
+
+	reader := newClient(client.WithToken("reader"))
+	require.NoError(t, reader.DownloadBucket(context.Background(), ID, t.TempDir()))
+	require.ErrorIs(t, reader.RemoveBucket(context.Background(), ID), ErrForbidden)
+
+	signed := newClient(client.WithSigningKey([]byte("key")))
+	_, err := signed.StatBucket(context.Background(), ID)
+	require.NoError(t, err)
+	require.NoError(t, signed.RemoveBucket(context.Background(), ID))
+	_, err = newClient(client.WithSigningKey([]byte("other key"))).StatBucket(context.Background(), ID)
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestPrefix(t *testing.T) {
+	_, srv := newTestServerWithAPI(t, config.APIConfig{Prefix: "/api"})
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaa"), 0644))
+
+	c, err := client.New(srv.URL, client.WithHTTPClient(srv.Client()), client.WithPrefix("/api"))
+	require.NoError(t, err)
+	require.NoError(t, c.UploadBucket(context.Background(), ID, source, nil))
+	require.NoError(t, c.DownloadBucket(context.Background(), ID, t.TempDir()))
+
+	c, err = client.New(srv.URL, client.WithHTTPClient(srv.Client()))
+	require.NoError(t, err)
+	_, err = c.StatBucket(context.Background(), ID)
+	var statusErr *client.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+}
+
+func TestErrorsMapToSentinels(t *testing.T) {
+	storage, srv := newTestServer(t)
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+
+	ID := newBucketID(t, "0000000000000000000000000000000000000001")
+
+	err = c.DownloadBucket(context.Background(), ID, t.TempDir())
+	require.ErrorIs(t, err, ErrBucketNotFound)
+	var statusErr *client.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+
+	_, commit, _, err := storage.ReserveBucket(context.Background(), ID, nil)
+	require.NoError(t, err)
+	require.NoError(t, commit())
+
+	err = c.DownloadFile(context.Background(), ID, "missing.txt", t.TempDir())
+	require.ErrorIs(t, err, ErrFileNotFound)
+
+	err = c.DownloadFile(context.Background(), ID, "../secret.txt", t.TempDir())
+	require.ErrorIs(t, err, ErrInvalidPath)
+
+	err = c.UploadFile(context.Background(), ID, "../secret.txt", t.TempDir())
+	require.ErrorIs(t, err, ErrInvalidPath)
+}
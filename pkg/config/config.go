@@ -1,12 +1,72 @@
 package config
 
 type Config struct {
-	RootDir string        `yaml:"root_dir" env:"ROOT_DIR"`
-	Trasher TrasherConfig `yaml:"trasher" env-prefix:"TRASHER_"`
+	RootDir string `yaml:"root_dir" env:"ROOT_DIR"`
+	// Durable makes commits fsync bucket files, meta and directories, so that committed
+	// buckets survive a power loss at the cost of slower commits.
+	Durable  bool           `yaml:"durable" env:"DURABLE"`
+	Trasher  TrasherConfig  `yaml:"trasher" env-prefix:"TRASHER_"`
+	Client   ClientConfig   `yaml:"client" env-prefix:"CLIENT_"`
+	Scrubber ScrubberConfig `yaml:"scrubber" env-prefix:"SCRUBBER_"`
+	Quota    QuotaConfig    `yaml:"quota" env-prefix:"QUOTA_"`
+	API      APIConfig      `yaml:"api" env-prefix:"API_"`
 }
 
+// TrasherConfig configures removal of expired buckets and eviction under disk pressure.
+// Expired buckets are kept in the trash for GracePeriod seconds, during which they can be restored;
+// with zero GracePeriod they are removed right away.
+// Once the stored files take more than HighWatermark bytes, the least recently accessed buckets
+// are removed until they take at most LowWatermark bytes. Eviction is disabled if HighWatermark is zero.
 type TrasherConfig struct {
-	Workers                  int `yaml:"workers" env:"WORKERS"`
-	CollectorIterationsDelay int `yaml:"collector_iterations_delay" env:"COLLECTOR_ITERATIONS_DELAY"`
-	WorkerIterationsDelay    int `yaml:"worker_iterations_delay" env:"WORKER_ITERATIONS_DELAY"`
+	Workers                  int   `yaml:"workers" env:"WORKERS"`
+	CollectorIterationsDelay int   `yaml:"collector_iterations_delay" env:"COLLECTOR_ITERATIONS_DELAY"`
+	WorkerIterationsDelay    int   `yaml:"worker_iterations_delay" env:"WORKER_ITERATIONS_DELAY"`
+	GracePeriod              int   `yaml:"grace_period" env:"GRACE_PERIOD"`
+	HighWatermark            int64 `yaml:"high_watermark" env:"HIGH_WATERMARK"`
+	LowWatermark             int64 `yaml:"low_watermark" env:"LOW_WATERMARK"`
+}
+
+// ClientConfig configures transfers from other nodes. Delays are in milliseconds.
+// Token is sent as a bearer token to nodes that require authentication.
+type ClientConfig struct {
+	Retries           int    `yaml:"retries" env:"RETRIES"`
+	RetryInitialDelay int    `yaml:"retry_initial_delay" env:"RETRY_INITIAL_DELAY"`
+	RetryMaxDelay     int    `yaml:"retry_max_delay" env:"RETRY_MAX_DELAY"`
+	Token             string `yaml:"token" env:"TOKEN"`
+}
+
+// ScrubberConfig configures the background integrity check of stored buckets.
+// The scrubber is disabled unless IterationsDelay (seconds between passes) is positive.
+// BytesPerSecond limits the read rate, zero means unlimited.
+// Corrupted buckets are moved out of the storage if Quarantine is set and only reported otherwise.
+type ScrubberConfig struct {
+	IterationsDelay int   `yaml:"iterations_delay" env:"ITERATIONS_DELAY"`
+	BytesPerSecond  int64 `yaml:"bytes_per_second" env:"BYTES_PER_SECOND"`
+	Quarantine      bool  `yaml:"quarantine" env:"QUARANTINE"`
+}
+
+// QuotaConfig bounds the disk usage of the storage. Sizes are in bytes; zero disables a bound.
+// MaxSize limits the total size of stored files, MinFreeSpace is kept free on the filesystem of the storage.
+type QuotaConfig struct {
+	MaxSize      int64 `yaml:"max_size" env:"MAX_SIZE"`
+	MinFreeSpace int64 `yaml:"min_free_space" env:"MIN_FREE_SPACE"`
+}
+
+// APIConfig configures the HTTP API. The versioned API is served at Prefix + "/v1",
+// e.g. /api/v1 for the "/api" prefix and /v1 without one. Nodes expect each other
+// to serve it under the same Prefix.
+// Requests are authenticated by one of Tokens or by URLs signed with SigningKey;
+// the API is open to anyone who can reach it if neither is set.
+type APIConfig struct {
+	Prefix     string        `yaml:"prefix" env:"PREFIX"`
+	Tokens     []TokenConfig `yaml:"tokens"`
+	SigningKey string        `yaml:"signing_key" env:"SIGNING_KEY"`
+}
+
+// TokenConfig is a bearer token and what it grants. Permissions are read, write and delete;
+// Prefixes limit the token to the buckets whose IDs start with one of them.
+type TokenConfig struct {
+	Token       string   `yaml:"token"`
+	Permissions []string `yaml:"permissions"`
+	Prefixes    []string `yaml:"prefixes"`
 }
@@ -10,6 +10,12 @@ var (
 	ErrInvalidPath         = errors.New("invalid relative path")
 	ErrInvalidArchive      = errors.New("invalid tar archive")
 	ErrArchiveTooLarge     = errors.New("tar archive exceeds limits")
+	ErrChecksumMismatch    = errors.New("checksum mismatch")
 	ErrWriteLocked         = errors.New("bucket is locked for write")
 	ErrReadLocked          = errors.New("bucket is locked for read")
+	ErrLockTimeout         = errors.New("timed out waiting for lock")
+	ErrQuotaExceeded       = errors.New("storage quota exceeded")
+	ErrPinNotFound         = errors.New("pin not found")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrForbidden           = errors.New("forbidden")
 )
@@ -2,33 +2,101 @@ package filestorage
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/DIvanCode/filestorage/internal/api/handler"
+	"github.com/DIvanCode/filestorage/internal/scrubber"
 	"github.com/DIvanCode/filestorage/internal/storage"
+	"github.com/DIvanCode/filestorage/pkg/auth"
 	"github.com/DIvanCode/filestorage/pkg/bucket"
 	"github.com/DIvanCode/filestorage/pkg/config"
 	"github.com/go-chi/chi/v5"
 )
 
+type (
+	// ScrubReport describes a single pass of the background integrity check.
+	ScrubReport = scrubber.Report
+	// Corruption describes a bucket whose contents do not match its digest manifest.
+	Corruption = scrubber.Corruption
+)
+
 type FileStorage interface {
 	ListBuckets(ctx context.Context) ([]bucket.ID, error)
+	ListBucketsPage(ctx context.Context, after bucket.ID, limit int, filter bucket.Filter) ([]bucket.ID, error)
 	GetBucket(ctx context.Context, id bucket.ID, extendTTL *time.Duration) (path string, unlock func(), err error)
 	GetBucketTrashTime(ctx context.Context, id bucket.ID) (*time.Time, error)
+	StatBucket(ctx context.Context, id bucket.ID) (bucket.Stat, error)
+	PinBucket(ctx context.Context, id bucket.ID, owner string, lease *time.Duration) (bucket.Pin, error)
+	UnpinBucket(ctx context.Context, id bucket.ID, pinID string) error
+	ListFiles(ctx context.Context, bucketID bucket.ID, prefix string, recursive bool) ([]bucket.Entry, error)
+	ListFilesPage(ctx context.Context, bucketID bucket.ID, prefix string, recursive bool, after string, limit int) ([]bucket.Entry, error)
 	GetFile(ctx context.Context, bucketID bucket.ID, file string, extendTTL *time.Duration) (path string, unlock func(), err error)
 	ReserveBucket(ctx context.Context, id bucket.ID, ttl *time.Duration) (path string, commit, abort func() error, err error)
 	ReserveFile(ctx context.Context, bucketID bucket.ID, file string) (path string, commit, abort func() error, err error)
 	DownloadBucket(ctx context.Context, endpoint string, id bucket.ID, ttl *time.Duration) error
+	DownloadBucketFrom(ctx context.Context, endpoints []string, id bucket.ID, ttl *time.Duration) error
 	DownloadFile(ctx context.Context, endpoint string, bucketID bucket.ID, file string) error
+	UploadBucket(ctx context.Context, endpoint string, id bucket.ID, ttl *time.Duration) error
+	UploadFile(ctx context.Context, endpoint string, bucketID bucket.ID, file string) error
+	RemoveBucket(ctx context.Context, id bucket.ID) error
+	RemoveFile(ctx context.Context, bucketID bucket.ID, file string) error
+	RestoreBucket(ctx context.Context, id bucket.ID, ttl *time.Duration) error
+	QuarantineBucket(ctx context.Context, id bucket.ID) error
+	LastScrub() (report ScrubReport, ok bool)
 	Shutdown()
 }
 
 func New(log *slog.Logger, cfg config.Config, mux *chi.Mux) (FileStorage, error) {
+	authenticator, err := newAuthenticator(cfg.API)
+	if err != nil {
+		return nil, err
+	}
+
 	s, err := storage.NewStorage(log, cfg)
 	if err != nil {
 		return nil, err
 	}
-	handler.NewHandler(s).Register(mux)
+
+	opts := []handler.Option{handler.WithPrefix(cfg.API.Prefix)}
+	if authenticator != nil {
+		opts = append(opts, handler.WithAuthenticator(authenticator))
+	}
+	handler.NewHandler(s, opts...).Register(mux)
 	return s, nil
 }
+
+// newAuthenticator builds the authenticator configured by cfg; it returns nil if none is.
+func newAuthenticator(cfg config.APIConfig) (auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+
+	if len(cfg.Tokens) > 0 {
+		grants := make(map[string]auth.Grant, len(cfg.Tokens))
+		for _, token := range cfg.Tokens {
+			permissions, err := auth.ParsePermissions(token.Permissions)
+			if err != nil {
+				return nil, fmt.Errorf("invalid api token config: %w", err)
+			}
+			grants[token.Token] = auth.Grant{Permissions: permissions, Prefixes: token.Prefixes}
+		}
+		tokens, err := auth.NewTokens(grants)
+		if err != nil {
+			return nil, fmt.Errorf("invalid api token config: %w", err)
+		}
+		authenticators = append(authenticators, tokens)
+	}
+
+	if cfg.SigningKey != "" {
+		signer, err := auth.NewSigner([]byte(cfg.SigningKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid api signing key: %w", err)
+		}
+		authenticators = append(authenticators, signer)
+	}
+
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return auth.Chain(authenticators...), nil
+}